@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"https/internal/headers"
 	"https/internal/request"
 	"https/internal/response"
+	"https/internal/router"
 	"https/internal/server"
 	"io"
 	"log"
@@ -14,31 +16,18 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 const port = 42069
+const shutdownTimeout = 30 * time.Second
 
-func getBodyResponse400() []byte { 
-	return []byte(`<html>
-	<head>
-	<title>400 Bad Request</title>
-	</head>
-	<body>
-	<h1>Bad Request</h1>
-	<p>Your request honestly kinda sucked.</p>
-	</body>
-	</html>`)
-}
+// errorPages answers API clients with problem+json and everyone else with
+// an HTML page.
+var errorPages = response.NewErrorPages()
 
-func getBodyResponse500() []byte {
-	return []byte(`<html>
-	<head>
-	<title>500 Internal Server Error</title>
-	</head>
-	<body>
-	<h1>Internal Server Error</h1>
-	<p>Okay, you know what? This one is on me.</p>
-	</body>
-	</html>`)
+// handle adapts an ErrorHandler with our error pages.
+func handle(h server.ErrorHandler) server.Handler {
+	return server.HandleErrorsWithRenderer(h, server.ErrorPagesRenderer(errorPages))
 }
 
 func getBodyResponse200() []byte {
@@ -53,24 +42,31 @@ func getBodyResponse200() []byte {
 	</html>`)
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
+func proxyHandler(w *response.Writer, req *request.Request) error {
 	h := response.GetDefaultHeaders(0)
 
-	target := req.RequestLine.RequestTarget 
-	url := "https://httpbin.org/" + target[len("/httpbin/"):]
+	url := "https://httpbin.org/" + strings.TrimPrefix(req.Target.RawPath, "/httpbin/")
+	if req.Target.RawQuery != "" {
+		url += "?" + req.Target.RawQuery
+	}
 	fmt.Println("Proxying to", url)
-	res, err := http.Get(url)
+	// stop pulling from httpbin as soon as the client is gone
+	upstream, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
 	if err != nil {
-		body := getBodyResponse500()
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.WriteHeaders(h)
-		w.WriteBody(body)
-		return
-	} 
+		return err
+	}
+	res, err := http.DefaultClient.Do(upstream)
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message: "Okay, you know what? This one is on me.",
+		}
+	}
+	defer res.Body.Close()
 
 	w.WriteStatusLine(response.StatusOk)
-	h.Delete("Content-Length")	
-	h.Set("Transfer-Encoding", "chunked")
+	h.Del("Content-Length")	
+	h.Add("Transfer-Encoding", "chunked")
 	h.Replace("Content-Type", "text/plain")
 	h.Replace("Trailer", "X-Content-Length, X-Content-Sha256")
 	w.WriteHeaders(h)
@@ -81,68 +77,87 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	for {
 		n, err := res.Body.Read(data)
 		if n > 0 {
-			_, err = w.WriteChunkedBody(data[:n])
-			if err != nil {
-				fmt.Println("Error when writing chunked body")
+			if _, err := w.WriteChunkedBody(data[:n]); err != nil {
+				return fmt.Errorf("error when writing chunked body: %w", err)
+			}
+			// pass each piece on as soon as httpbin sends it
+			if err := w.Flush(); err != nil {
+				return err
 			}
 			xContentLength += n
 		}
 		if err == io.EOF {
 			break
 		}
-
+		// ending the chunked body now would pass a truncated response
+		// off as complete
 		if err != nil {
-			fmt.Println("Error responding to body:", err)
+			return fmt.Errorf("error reading from httpbin: %w", err)
 		}
 	}
 
 	_, err = w.WriteChunkedBodyDone()
 	fmt.Println("Done with chunk")
 	if err != nil {
-		fmt.Println("Error writing BodyDone", err)
+		return fmt.Errorf("error writing BodyDone: %w", err)
 	}
 	trailers := headers.NewHeaders()
 	checkSum := sha256.Sum256(w.BodyResponse)
 	hash := checkSum[:]
-	trailers.Set("X-Content-Sha256", fmt.Sprintf("%x", hash))
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", xContentLength))
+	trailers.Add("X-Content-Sha256", fmt.Sprintf("%x", hash))
+	trailers.Add("X-Content-Length", fmt.Sprintf("%d", xContentLength))
 	fmt.Println("X-Content-Sha256: ", trailers.Get("X-Content-Sha256"))
-	w.WriteTrailers(trailers)
+	return w.WriteTrailers(trailers)
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	h := response.GetDefaultHeaders(0)
-	body := getBodyResponse200()
-	status := response.StatusOk
-
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
-
-	} else if req.RequestLine.RequestTarget == "/yourproblem" {	
-		body = getBodyResponse400()
-		status = response.StatusBadRequest
+// htmlHandler answers with a fixed HTML page.
+func htmlHandler(status response.StatusCode, body []byte) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.Header().Replace("Content-Type", "text/html")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
 
-	} else if req.RequestLine.RequestTarget == "/myproblem" {
-		body = getBodyResponse500()
-		status = response.StatusInternalServerError
-	} 
+// logRequests logs every request with the status and size of its response.
+func logRequests(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		log.Printf("%s %s %d %dB %v", req.RequestLine.Method, req.RequestLine.RequestTarget, w.StatusCode(), w.BytesWritten(), time.Since(start))
+	}
+}
 
-	h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-	w.WriteStatusLine(status)
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+func newRouter() *router.Router {
+	rt := router.New()
+	rt.Get("/httpbin/{path...}", handle(proxyHandler))
+	rt.Get("/yourproblem", handle(func(w *response.Writer, req *request.Request) error {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: "Your request honestly kinda sucked."}
+	}))
+	rt.Get("/myproblem", handle(func(w *response.Writer, req *request.Request) error {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: "Okay, you know what? This one is on me."}
+	}))
+	rt.NotFound = htmlHandler(response.StatusOk, getBodyResponse200())
+	return rt
 }
 
 func main() {
-	server, err := server.Serve(port, handler)
+	server, err := server.Serve(port, server.NewChain(logRequests).Then(newRouter().ServeRequest))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	dropped, err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stopped, dropped %d connections: %v", dropped, err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
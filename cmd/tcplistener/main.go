@@ -34,19 +34,26 @@ func main() {
 
 		fmt.Println("New connection accepted")	
 		go func(c net.Conn) {
-			r, err := request.RequestFromReader(c)
+			// lenient, so that odd requests are shown rather than rejected
+			opts := request.DefaultOptions()
+			opts.Lenient = true
+			r, err := request.RequestFromReaderWithOptions(c, opts)
 			if err != nil {
 				log.Fatal(err)
 				return
 			}
 
 			rl := r.RequestLine
-			fmt.Printf("Request line:\n- Method: %s\n- Target: %s\n- Version: %s", rl.Method, rl.RequestTarget, rl.HttpVersion)
+			fmt.Printf("Request line:\n- Method: %s\n- Target: %s\n- Version: %s", rl.Method, rl.RequestTarget, rl.HTTPVersion)
 			fmt.Printf("\nHeaders:\n")
-			for k, v := range r.Headers.All() {
-				fmt.Printf("- %s: %s\n", k, v)
+			for _, f := range r.Headers.Fields() {
+				fmt.Printf("- %s: %s\n", f.Name, f.Value)
 			}
-			if r.Body.ContentLength > 0 {fmt.Printf("Body:\n%s", r.Body.Body)}
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Printf("error reading body: %v", err)
+			}
+			if len(b) > 0 {fmt.Printf("Body:\n%s", b)}
 		}(conn)
 	}
 }
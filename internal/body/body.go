@@ -1,48 +1,57 @@
-// Package body provides a small helper for incrementally assembling a fixed-
-// length request body from arbitrary byte chunks (e.g., as they arrive from a
-// network connection). The caller sets the expected byte length up front and
-// then feeds chunks via Parse until the body is complete.
+// Package body strips the message framing from a request body as its bytes
+// arrive from a network connection, without holding the payload in memory.
+// A Decoder is fed whatever bytes are buffered so far and copies the payload
+// it finds into a caller-supplied slice, so a body can be streamed through a
+// fixed-size buffer no matter how large it is.
 package body
 
-// Body holds state for assembling a fixed-length payload.
+// Decoder is implemented by each body framing.
+//
+// Decode copies payload bytes from src into dst, returning
+// (written, consumed, done, err):
+//   - written: how many payload bytes were copied into dst
+//   - consumed: how many bytes from src were used, framing included
+//   - done: whether the end of the body has been reached
+//   - err: non-nil only on malformed framing
+//
+// Decode never consumes bytes past the end of the body, so whatever is left
+// in src belongs to the next message on the connection.
+type Decoder interface {
+	Decode(dst, src []byte) (written int, consumed int, done bool, err error)
+}
+
+// Body decodes a fixed-length payload, as announced by Content-Length.
 //
 // Invariants:
 //   - ContentLength is the total number of bytes expected.
-//   - len(Body) is the number of bytes accumulated so far.
-//   - When len(Body) == ContentLength, the body is complete.
+//   - Read is the number of bytes decoded so far.
+//   - When Read == ContentLength, the body is complete.
 //
 // This type is NOT safe for concurrent use without external synchronization.
 type Body struct {
-	Body string
 	ContentLength int // Must be >= 0
+	Read          int
 }
 
 func NewBody() *Body {
-	return &Body { Body: string(""),
-		ContentLength: 0,
-	}
+	return &Body{ContentLength: 0}
 }
 
 func (b *Body) SetLength(cl int) {
 	b.ContentLength = cl
 }
 
-// Parse appends up to the remaining number of bytes from data into the Body,
-// returning (consumed, done, err):
-//   - consumed: how many bytes from data were used
-//   - done: whether the body is now complete (len(Body) == ContentLength)
-//   - err: non-nil only on error (e.g., ContentLength < current size)
-//
-// Parse never over-reads beyond ContentLength. Extra bytes in data are not
-// consumed and should be supplied to the next higher-level parser in request
-// min b/c we want to make sure that we only parse the Body
-// prevent parsing next request ["BODY" + some of REQUEST2]
-func (b *Body) Parse(data []byte) (consumed int, done bool, err error) {	
-	remaining := min(b.ContentLength - len(b.Body), len(data))
-	b.Body += string(data[:remaining])
+// Done reports whether all ContentLength bytes have been decoded.
+func (b *Body) Done() bool {
+	return b.Read == b.ContentLength
+}
 
-	if len(b.Body) == b.ContentLength {
-		return remaining, true, nil
-	}
-	return remaining, false, nil
+// Decode implements Decoder.
+// min b/c we want to make sure that we only decode the Body
+// prevent decoding next request ["BODY" + some of REQUEST2]
+func (b *Body) Decode(dst, src []byte) (written int, consumed int, done bool, err error) {
+	remaining := min(b.ContentLength-b.Read, len(src))
+	n := copy(dst, src[:remaining])
+	b.Read += n
+	return n, n, b.Done(), nil
 }
@@ -1,6 +1,8 @@
 package body
 
 import (
+	"fmt"
+	"math"
 	"testing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -11,10 +13,11 @@ func TestBody(t *testing.T) {
 	body := NewBody()
 	data := []byte("hello world @!@\n")
 	body.ContentLength = len(data)
-	n, done, err := body.Parse(data)
+	dst := make([]byte, 64)
+	w, n, done, err := body.Decode(dst, data)
 	require.NoError(t, err)
 	require.NotNil(t, body)
-	assert.Equal(t, "hello world @!@\n", body.Body)
+	assert.Equal(t, "hello world @!@\n", string(dst[:w]))
 	assert.Equal(t, 16, n)
 	assert.True(t, done)
 
@@ -23,9 +26,110 @@ func TestBody(t *testing.T) {
 	body = NewBody()
 	data = []byte("hello world @!@\n")
 	body.ContentLength = 18
-	_, done, err = body.Parse(data)
+	w, _, done, err = body.Decode(dst, data)
 	require.NoError(t, err)
 	require.NotNil(t, body)
-	assert.Equal(t, "hello world @!@\n", body.Body)
+	assert.Equal(t, "hello world @!@\n", string(dst[:w]))
 	assert.False(t, done)
+
+	// Test bytes after the body are not consumed
+	body = NewBody()
+	body.ContentLength = 5
+	w, n, done, err = body.Decode(dst, []byte("helloGET"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(dst[:w]))
+	assert.Equal(t, 5, n)
+	assert.True(t, done)
+
+	// Test dst smaller than the body
+	body = NewBody()
+	body.ContentLength = 5
+	w, n, done, err = body.Decode(dst[:2], []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "he", string(dst[:w]))
+	assert.Equal(t, 2, n)
+	assert.False(t, done)
+}
+
+func TestChunked(t *testing.T) {
+	// Test: Standard chunked body with extension and trailer
+	chunked := NewChunked()
+	data := []byte("5;name=value\r\nhello\r\n7\r\n world!\r\n0\r\nExpires: never\r\n\r\nGET")
+	dst := make([]byte, 64)
+	w, n, done, err := chunked.Decode(dst, data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, len(data)-len("GET"), n)
+	assert.Equal(t, "hello world!", string(dst[:w]))
+	assert.Equal(t, 12, chunked.Size)
+	assert.Equal(t, "never", chunked.Trailers.Get("expires"))
+
+	// Test: Incomplete chunk-size line consumes nothing
+	chunked = NewChunked()
+	w, n, done, err = chunked.Decode(dst, []byte("1f"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, w)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Chunk split across calls
+	chunked = NewChunked()
+	w, n, done, err = chunked.Decode(dst, []byte("a\r\n0123"))
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(dst[:w]))
+	assert.Equal(t, 7, n)
+	assert.False(t, done)
+	w, n, done, err = chunked.Decode(dst, []byte("456789\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "456789", string(dst[:w]))
+	assert.Equal(t, 13, n)
+	assert.True(t, done)
+
+	// Test: dst smaller than the chunk
+	chunked = NewChunked()
+	w, n, done, err = chunked.Decode(dst[:3], []byte("5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hel", string(dst[:w]))
+	assert.Equal(t, 6, n)
+	assert.False(t, done)
+
+	// Test: Invalid chunk-size
+	chunked = NewChunked()
+	_, _, _, err = chunked.Decode(dst, []byte("xyz\r\nhello\r\n"))
+	require.ErrorIs(t, err, ErrBadChunkSize)
+
+	// Test: Chunk sizes up to the largest int
+	chunked = NewChunked()
+	w, n, done, err = chunked.Decode(dst, []byte("10000000\r\nabc"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(dst[:w]))
+	assert.Equal(t, 1<<28, chunked.Size)
+	chunked = NewChunked()
+	_, _, _, err = chunked.Decode(dst, []byte(fmt.Sprintf("%x\r\n", math.MaxInt)))
+	require.NoError(t, err)
+	_, _, _, err = NewChunked().Decode(dst, []byte(fmt.Sprintf("%x\r\n", uint64(math.MaxInt)+1)))
+	require.ErrorIs(t, err, ErrBadChunkSize)
+	_, _, _, err = NewChunked().Decode(dst, []byte("10000000000000000\r\n"))
+	require.ErrorIs(t, err, ErrBadChunkSize)
+
+	// Test: Chunk data longer than chunk-size
+	chunked = NewChunked()
+	_, _, _, err = chunked.Decode(dst, []byte("3\r\nhello\r\n"))
+	require.ErrorIs(t, err, ErrBadChunkTerminator)
+
+	// Test: Trailer limits
+	trailers := []byte("0\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n")
+	chunked = NewChunked()
+	chunked.SetTrailerLimits(2, 0)
+	_, _, _, err = chunked.Decode(dst, trailers)
+	require.ErrorIs(t, err, ErrTooManyTrailers)
+	chunked = NewChunked()
+	chunked.SetTrailerLimits(0, 10)
+	_, _, _, err = chunked.Decode(dst, trailers)
+	require.ErrorIs(t, err, ErrTrailersTooLarge)
+	chunked = NewChunked()
+	chunked.SetTrailerLimits(3, 20)
+	_, _, done, err = chunked.Decode(dst, trailers)
+	require.NoError(t, err)
+	assert.True(t, done)
 }
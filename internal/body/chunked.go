@@ -0,0 +1,163 @@
+package body
+
+import (
+	"bytes"
+	"fmt"
+	"https/internal/headers"
+	"math"
+	"strconv"
+)
+
+// Chunked decodes a body sent with "Transfer-Encoding: chunked" (RFC 9112
+// section 7.1). Trailer fields are added to Trailers as they are decoded, so
+// they are only complete once Done reports true.
+//
+//	chunked-body = *chunk last-chunk trailer-section CRLF
+//	chunk        = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+//	last-chunk   = 1*("0") [ chunk-ext ] CRLF
+//
+// This type is NOT safe for concurrent use without external synchronization.
+type Chunked struct {
+	Trailers  *headers.Headers
+	Size      int // chunk-data bytes announced so far
+	state     chunkedState
+	remaining int // bytes left in the current chunk-data
+
+	maxTrailerCount int // 0 for no limit
+	maxTrailerBytes int // 0 for no limit
+	trailerCount    int
+	trailerBytes    int
+}
+
+type chunkedState int
+
+const (
+	chunkedStateSize chunkedState = iota
+	chunkedStateData
+	chunkedStateDataCRLF
+	chunkedStateTrailers
+	chunkedStateDone
+)
+
+var ErrBadChunkSize = fmt.Errorf("bad chunk-size")
+var ErrBadChunkTerminator = fmt.Errorf("chunk-data not followed by CRLF")
+var ErrTooManyTrailers = fmt.Errorf("too many trailer fields")
+var ErrTrailersTooLarge = fmt.Errorf("trailer section too large")
+
+var crlf = []byte("\r\n")
+
+func NewChunked() *Chunked {
+	return &Chunked{
+		Trailers: headers.NewHeaders(),
+		state:    chunkedStateSize,
+	}
+}
+
+// SetTrailerLimits caps the number of trailer fields and the size of the
+// trailer section, 0 meaning no limit; without them a client can make the
+// decoder keep any number of fields.
+func (c *Chunked) SetTrailerLimits(maxCount int, maxBytes int) {
+	c.maxTrailerCount = maxCount
+	c.maxTrailerBytes = maxBytes
+}
+
+// Done reports whether the last-chunk and trailer section have been read.
+func (c *Chunked) Done() bool {
+	return c.state == chunkedStateDone
+}
+
+// Decode implements Decoder. It stops early once dst is full; the framing
+// around the remaining chunk-data is decoded on the next call.
+func (c *Chunked) Decode(dst, src []byte) (written int, consumed int, done bool, err error) {
+	for c.state != chunkedStateDone {
+		w, n, err := c.decodeSingle(dst[written:], src[consumed:])
+		if err != nil {
+			return written, consumed, false, err
+		}
+		if n == 0 {
+			break
+		}
+		written += w
+		consumed += n
+	}
+	return written, consumed, c.state == chunkedStateDone, nil
+}
+
+func (c *Chunked) decodeSingle(dst, src []byte) (int, int, error) {
+	switch c.state {
+	case chunkedStateSize:
+		idx := bytes.Index(src, crlf)
+		if idx == -1 {
+			return 0, 0, nil
+		}
+		size, err := parseChunkSize(src[:idx])
+		if err != nil {
+			return 0, 0, err
+		}
+		if size > math.MaxInt-c.Size {
+			return 0, 0, fmt.Errorf("%w: body size overflows an int", ErrBadChunkSize)
+		}
+		if size == 0 {
+			c.state = chunkedStateTrailers
+		} else {
+			c.remaining = size
+			c.Size += size
+			c.state = chunkedStateData
+		}
+		return 0, idx + len(crlf), nil
+	case chunkedStateData:
+		n := copy(dst, src[:min(c.remaining, len(src))])
+		c.remaining -= n
+		if c.remaining == 0 {
+			c.state = chunkedStateDataCRLF
+		}
+		return n, n, nil
+	case chunkedStateDataCRLF:
+		if len(src) < len(crlf) {
+			return 0, 0, nil
+		}
+		if !bytes.HasPrefix(src, crlf) {
+			return 0, 0, ErrBadChunkTerminator
+		}
+		c.state = chunkedStateSize
+		return 0, len(crlf), nil
+	case chunkedStateTrailers:
+		n, done, err := c.Trailers.Parse(src)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n > 0 && !done {
+			c.trailerCount++
+		}
+		c.trailerBytes += n
+		if c.maxTrailerCount > 0 && c.trailerCount > c.maxTrailerCount {
+			return 0, 0, ErrTooManyTrailers
+		}
+		if c.maxTrailerBytes > 0 && c.trailerBytes > c.maxTrailerBytes {
+			return 0, 0, ErrTrailersTooLarge
+		}
+		if done {
+			c.state = chunkedStateDone
+		}
+		return 0, n, nil
+	}
+	return 0, 0, fmt.Errorf("unknown chunked state")
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk-ext. Any size
+// an int can hold is accepted; how much body is too much is for the
+// caller's body limit to decide.
+func parseChunkSize(line []byte) (int, error) {
+	if idx := bytes.IndexByte(line, ';'); idx != -1 {
+		line = line[:idx]
+	}
+	line = bytes.TrimRight(line, " \t") // BWS before chunk-ext
+	if len(line) == 0 {
+		return 0, ErrBadChunkSize
+	}
+	size, err := strconv.ParseUint(string(line), 16, 64)
+	if err != nil || size > math.MaxInt {
+		return 0, ErrBadChunkSize
+	}
+	return int(size), nil
+}
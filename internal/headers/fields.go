@@ -0,0 +1,442 @@
+package headers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadContentLength = fmt.Errorf("bad Content-Length")
+var ErrContentLengthRange = fmt.Errorf("Content-Length out of range")
+var ErrConflictingContentLength = fmt.Errorf("more than one Content-Length")
+var ErrBadMediaType = fmt.Errorf("bad media type")
+var ErrBadDate = fmt.Errorf("bad HTTP date")
+var ErrBadList = fmt.Errorf("bad list element")
+var ErrBadWeight = fmt.Errorf("bad weight")
+
+// ParseContentLength parses a Content-Length value, 1*DIGIT (RFC 9110 8.6).
+// Signs and whitespace are not digits, so they are rejected like any other
+// character; a value too big for an int64 gives ErrContentLengthRange.
+func ParseContentLength(value string) (int64, error) {
+	if value == "" {
+		return 0, ErrBadContentLength
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, ErrBadContentLength
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil { // all digits, so it can only be out of range
+		return 0, ErrContentLengthRange
+	}
+	return n, nil
+}
+
+func FormatContentLength(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// ContentLength returns the Content-Length of h, or -1 if there is none.
+// More than one value, in repeated field lines or as a list in one, gives
+// ErrConflictingContentLength in Strict mode; Lenient mode accepts them
+// when they are all the same (RFC 9110 8.6).
+func (h *Headers) ContentLength(mode ParseMode) (int64, error) {
+	var values []string
+	for _, value := range h.Values("content-length") {
+		values = append(values, strings.Split(value, ",")...)
+	}
+	if len(values) == 0 {
+		return -1, nil
+	}
+	if len(values) > 1 && mode == Strict {
+		return 0, ErrConflictingContentLength
+	}
+	length := int64(-1)
+	for _, v := range values {
+		if mode == Lenient {
+			v = strings.Trim(v, " \t")
+		}
+		n, err := ParseContentLength(v)
+		if err != nil {
+			return 0, err
+		}
+		if length != -1 && n != length {
+			return 0, ErrConflictingContentLength
+		}
+		length = n
+	}
+	return length, nil
+}
+
+func (h *Headers) SetContentLength(n int64) {
+	h.Replace("Content-Length", FormatContentLength(n))
+}
+
+// MediaType is a parsed Content-Type or Accept element, e.g.
+// "text/html; charset=utf-8". Type is lowercased, "type/subtype"; parameter
+// names are lowercased, values kept as they were.
+type MediaType struct {
+	Type   string
+	Params map[string]string
+}
+
+// Param returns the value of parameter name, or "".
+func (m MediaType) Param(name string) string {
+	return m.Params[strings.ToLower(name)]
+}
+
+func (m MediaType) Charset() string {
+	return m.Param("charset")
+}
+
+// Boundary returns the boundary parameter of a multipart type.
+func (m MediaType) Boundary() string {
+	return m.Param("boundary")
+}
+
+// ParseMediaType parses media-type = type "/" subtype parameters
+// (RFC 9110 8.3.1).
+func ParseMediaType(value string) (MediaType, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.Trim(mediaType, " \t"))
+	typ, subtype, ok := strings.Cut(mediaType, "/")
+	if !ok || !ValidFieldName(typ) || !ValidFieldName(subtype) {
+		return MediaType{}, fmt.Errorf("%w: %q", ErrBadMediaType, value)
+	}
+	params, err := parseParams(rest)
+	if err != nil {
+		return MediaType{}, fmt.Errorf("%w: %q", ErrBadMediaType, value)
+	}
+	return MediaType{Type: mediaType, Params: params}, nil
+}
+
+// FormatMediaType writes m back as a field value, with its parameters sorted
+// so the same MediaType always gives the same output.
+func FormatMediaType(m MediaType) string {
+	var b strings.Builder
+	b.WriteString(m.Type)
+	names := make([]string, 0, len(m.Params))
+	for name := range m.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("; ")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(quoteIfNeeded(m.Params[name]))
+	}
+	return b.String()
+}
+
+// ContentType returns the parsed Content-Type of h, or a zero MediaType if
+// there is none.
+func (h *Headers) ContentType() (MediaType, error) {
+	value := h.Get("content-type")
+	if value == "" {
+		return MediaType{}, nil
+	}
+	return ParseMediaType(value)
+}
+
+func (h *Headers) SetContentType(m MediaType) {
+	h.Replace("Content-Type", FormatMediaType(m))
+}
+
+// Connection returns the lowercased connection options of h, from every
+// Connection field line (RFC 9110 7.6.1).
+func (h *Headers) Connection() ([]string, error) {
+	tokens, err := ParseTokenList(h.Get("connection"))
+	for i, t := range tokens {
+		tokens[i] = strings.ToLower(t)
+	}
+	return tokens, err
+}
+
+func (h *Headers) SetConnection(options ...string) {
+	h.Replace("Connection", FormatTokenList(options))
+}
+
+// TimeFormat is IMF-fixdate, the only format a sender may generate
+// (RFC 9110 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete date formats that a recipient must still accept
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ParseDate parses an HTTP-date in IMF-fixdate or one of the obsolete RFC
+// 850 and asctime formats. The result is in UTC.
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrBadDate, value)
+}
+
+func FormatDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// Date returns the date in field name, e.g. "Date" or "If-Modified-Since",
+// and false if there is none or it is malformed: RFC 9110 13.1.3 has a
+// recipient ignore an invalid date rather than reject the message.
+func (h *Headers) Date(name string) (time.Time, bool) {
+	value := h.Get(name)
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := ParseDate(value)
+	return t, err == nil
+}
+
+func (h *Headers) SetDate(name string, t time.Time) {
+	h.Replace(name, FormatDate(t))
+}
+
+// Weighted is an element of an Accept-style list: a value with optional
+// parameters and a weight, e.g. "text/html;level=1;q=0.5" (RFC 9110 12.4.2).
+type Weighted struct {
+	Value  string
+	Params map[string]string
+	Q      float64
+}
+
+// ParseWeightedList parses a list like Accept, Accept-Encoding or
+// Accept-Language, in the order given. Elements without a q parameter weigh
+// 1; parameters after q (accept-ext) are dropped.
+func ParseWeightedList(value string) ([]Weighted, error) {
+	var list []Weighted
+	for _, element := range splitList(value) {
+		v, rest, _ := strings.Cut(element, ";")
+		v = strings.Trim(v, " \t")
+		w := Weighted{Value: v, Q: 1}
+		if v == "" {
+			return nil, fmt.Errorf("%w: %q", ErrBadList, element)
+		}
+		for _, param := range splitOutsideQuotes(rest, ';') {
+			name, pv, ok := strings.Cut(strings.Trim(param, " \t"), "=")
+			name = strings.ToLower(strings.Trim(name, " \t"))
+			if name == "" && !ok {
+				continue
+			}
+			if name == "q" {
+				q, err := parseWeight(strings.Trim(pv, " \t"))
+				if err != nil {
+					return nil, err
+				}
+				w.Q = q
+				break
+			}
+			pv, err := unquoteIfNeeded(strings.Trim(pv, " \t"))
+			if !ok || !ValidFieldName(name) || err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrBadList, element)
+			}
+			if w.Params == nil {
+				w.Params = map[string]string{}
+			}
+			w.Params[name] = pv
+		}
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+// parseWeight parses qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] ).
+func parseWeight(value string) (float64, error) {
+	whole, frac, hasFrac := strings.Cut(value, ".")
+	if (whole != "0" && whole != "1") || len(frac) > 3 {
+		return 0, fmt.Errorf("%w: %q", ErrBadWeight, value)
+	}
+	for i := 0; i < len(frac); i++ {
+		if frac[i] < '0' || frac[i] > '9' || (whole == "1" && frac[i] != '0') {
+			return 0, fmt.Errorf("%w: %q", ErrBadWeight, value)
+		}
+	}
+	if !hasFrac || frac == "" {
+		return float64(whole[0] - '0'), nil
+	}
+	q, _ := strconv.ParseFloat(value, 64)
+	return q, nil
+}
+
+// FormatWeightedList writes list back as a field value; q is left out for a
+// weight of 1 and rounded to the three decimals a qvalue allows otherwise.
+func FormatWeightedList(list []Weighted) string {
+	elements := make([]string, 0, len(list))
+	for _, w := range list {
+		element := FormatMediaType(MediaType{Type: w.Value, Params: w.Params})
+		if w.Q < 1 {
+			q := math.Round(math.Max(w.Q, 0)*1000) / 1000
+			element += ";q=" + strconv.FormatFloat(q, 'f', -1, 64)
+		}
+		elements = append(elements, element)
+	}
+	return strings.Join(elements, ", ")
+}
+
+// ParseTokenList parses a comma-separated list of tokens, e.g. Connection
+// or Trailer. Empty elements are skipped, as RFC 9110 5.6.1 requires.
+func ParseTokenList(value string) ([]string, error) {
+	var tokens []string
+	for _, element := range splitList(value) {
+		if !ValidFieldName(element) {
+			return nil, fmt.Errorf("%w: %q", ErrBadList, element)
+		}
+		tokens = append(tokens, element)
+	}
+	return tokens, nil
+}
+
+func FormatTokenList(tokens []string) string {
+	return strings.Join(tokens, ", ")
+}
+
+// Tokens returns the tokens in every field line named name.
+func (h *Headers) Tokens(name string) ([]string, error) {
+	return ParseTokenList(h.Get(name))
+}
+
+// ParseQuotedStringList parses a comma-separated list whose elements are
+// quoted-strings or tokens, returning them unquoted. Commas inside a
+// quoted-string do not split it.
+func ParseQuotedStringList(value string) ([]string, error) {
+	var values []string
+	for _, element := range splitList(value) {
+		v, err := unquoteIfNeeded(element)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// FormatQuotedStringList writes every value as a quoted-string.
+func FormatQuotedStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = Quote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Quote returns s as a quoted-string, escaping '"' and '\' (RFC 9110 5.6.4).
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote returns the content of a quoted-string.
+func Unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("%w: not a quoted-string: %q", ErrBadList, s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s)-1:
+			i++
+			c = s[i]
+		case c == '\\' || c == '"':
+			return "", fmt.Errorf("%w: bad quoted-string: %q", ErrBadList, s)
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// quoteIfNeeded leaves a token as it is and quotes anything else.
+func quoteIfNeeded(s string) string {
+	if ValidFieldName(s) {
+		return s
+	}
+	return Quote(s)
+}
+
+// unquoteIfNeeded accepts token / quoted-string.
+func unquoteIfNeeded(s string) (string, error) {
+	if strings.HasPrefix(s, "\"") {
+		return Unquote(s)
+	}
+	if !ValidFieldName(s) {
+		return "", fmt.Errorf("%w: %q", ErrBadList, s)
+	}
+	return s, nil
+}
+
+// parseParams parses *( OWS ";" OWS [ parameter ] ), without the first ";".
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, param := range splitOutsideQuotes(s, ';') {
+		param = strings.Trim(param, " \t")
+		if param == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(param, "=")
+		name = strings.ToLower(name)
+		if !ok || !ValidFieldName(name) {
+			return nil, ErrBadMediaType
+		}
+		if _, dup := params[name]; dup {
+			return nil, ErrBadMediaType
+		}
+		value, err := unquoteIfNeeded(value)
+		if err != nil {
+			return nil, err
+		}
+		params[name] = value
+	}
+	if len(params) == 0 {
+		return nil, nil
+	}
+	return params, nil
+}
+
+// splitList splits a comma-separated list into its trimmed, non-empty
+// elements.
+func splitList(value string) []string {
+	var elements []string
+	for _, element := range splitOutsideQuotes(value, ',') {
+		if element = strings.Trim(element, " \t"); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// splitOutsideQuotes splits s at every sep that is not inside a
+// quoted-string.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
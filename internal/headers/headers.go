@@ -6,45 +6,165 @@ import (
 	"bytes"
 )
 
+// Field is one field line, with the name as it was set or received.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers is a header or trailer section: the field lines in the order they
+// were added, duplicates included, with their original casing. Lookups by
+// name are case-insensitive.
+//
+// Repeated fields are kept as separate lines rather than joined, since some
+// of them cannot be: Set-Cookie values contain commas (RFC 6265 3). Use
+// Values for those; Get joins the lines as RFC 9110 5.3 allows for fields
+// defined as lists.
+//
+// A Headers copied by value shares its field lines with the original until
+// one of them is changed; use Clone for an independent copy.
 type Headers struct {
-	headers map[string]string
+	fields []Field
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
-	}
+	return &Headers{}
 }
 
+// Get returns the values of all field lines named name joined with ", ", or
+// "" if there are none.
 func (h *Headers) Get(name string) string {
-	return h.headers[strings.ToLower(name)]
+	if h == nil {
+		return ""
+	}
+	value, found := "", false
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			if found {
+				value += ", " + f.Value
+			} else {
+				value, found = f.Value, true
+			}
+		}
+	}
+	return value
 }
 
-func (h *Headers) Set(name string, value string) {
-	name = strings.ToLower(name)
+// Values returns the value of every field line named name, in order.
+func (h *Headers) Values(name string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
 
-	if v, ok := h.headers[name]; ok {
-		h.headers[name] = fmt.Sprintf("%s, %s", v, value)
-	} else {
-		h.headers[name] = value
+// Has reports whether there is at least one field line named name.
+func (h *Headers) Has(name string) bool {
+	if h == nil {
+		return false
 	}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a field line, after any others with the same name.
+func (h *Headers) Add(name string, value string) {
+	// the full slice expression makes append copy, so a Headers copied by
+	// value never sees lines added to the other
+	h.fields = append(h.fields[:len(h.fields):len(h.fields)], Field{Name: name, Value: value})
 }
 
+// Replace sets the value of field name, keeping the position and casing of
+// its first line and dropping the others. A field not there yet is added.
 func (h *Headers) Replace(name string, value string) {
-	name = strings.ToLower(name)
-	h.headers[name] = value	
+	fields := make([]Field, 0, len(h.fields)+1)
+	replaced := false
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			if replaced {
+				continue
+			}
+			f.Value, replaced = value, true
+		}
+		fields = append(fields, f)
+	}
+	if !replaced {
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	h.fields = fields
 }
 
-func (h *Headers) Delete(name string) {
-	name = strings.ToLower(name)
-	delete(h.headers, name)
+// Del removes every field line named name.
+func (h *Headers) Del(name string) {
+	if !h.Has(name) {
+		return
+	}
+	// a new slice, so a copy of h made by value keeps its own lines
+	fields := make([]Field, 0, len(h.fields))
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.Name, name) {
+			fields = append(fields, f)
+		}
+	}
+	h.fields = fields
 }
 
-func (h *Headers) All() map[string]string {
+// Clone returns a copy of h that shares nothing with it.
+func (h *Headers) Clone() *Headers {
 	if h == nil {
 		return nil
 	}
-	return h.headers
+	return &Headers{fields: append([]Field(nil), h.fields...)}
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// Range calls f for every field line in order, until f returns false.
+func (h *Headers) Range(f func(name string, value string) bool) {
+	if h == nil {
+		return
+	}
+	for _, field := range h.fields {
+		if !f(field.Name, field.Value) {
+			return
+		}
+	}
+}
+
+// Fields returns a copy of the field lines as they were added or received,
+// for tools that show a message as it was sent.
+func (h *Headers) Fields() []Field {
+	if h == nil {
+		return nil
+	}
+	return append([]Field(nil), h.fields...)
+}
+
+// HasToken reports whether the comma-separated list in field name contains
+// token, compared case-insensitively (e.g. "Connection: keep-alive, Upgrade").
+func (h *Headers) HasToken(name string, token string) bool {
+	for _, t := range strings.Split(h.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
 }
 
 func isTokenChar(r rune) bool {
@@ -63,11 +183,60 @@ func isTokenChar(r rune) bool {
 	return false
 }
 
+// ValidFieldName reports whether name is a token (RFC 9110 5.1).
+func ValidFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !isTokenChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidFieldValue reports whether value holds only visible characters,
+// obs-text, SP and HTAB (RFC 9110 5.5). In particular it rejects CR and LF,
+// which would let the value end the field line and start another.
+func ValidFieldValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 var ErrFieldNameContainsSpace = fmt.Errorf("field name contains space")
 var ErrBadFieldName = fmt.Errorf("bad field-name")
+var ErrMalformedFieldLine = fmt.Errorf("field line without a colon")
+var ErrObsFold = fmt.Errorf("obsolete line folding")
+var ErrBadFieldValue = fmt.Errorf("field value contains NUL, CR or LF")
 var CRLF = []byte("\r\n")
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// ParseMode selects how forgiving Parse is with field lines that RFC 9112
+// lets a recipient either reject or repair.
+type ParseMode int
+
+const (
+	// Strict rejects whitespace before the colon, obs-fold continuation
+	// lines and NUL, CR or LF inside a value. Use it for anything that sits
+	// behind another HTTP hop, where two parsers disagreeing about a field is
+	// how requests get smuggled.
+	Strict ParseMode = iota
+	// Lenient repairs those lines instead: whitespace before the colon is
+	// dropped, obs-fold is unfolded into the previous field's value and
+	// NUL, CR and LF become SP (RFC 9110 5.5). Only for debugging tools.
+	Lenient
+)
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithMode(data, Strict)
+}
+
+func (h *Headers) ParseWithMode(data []byte, mode ParseMode) (n int, done bool, err error) {
 	idx := bytes.Index(data, CRLF)
 	if idx == -1 {
 		return 0, false, nil
@@ -80,11 +249,27 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	consumedN := idx + len(CRLF)
 
 	field := data[:idx]
+	if field[0] == ' ' || field[0] == '\t' { // obs-fold = OWS CRLF RWS
+		if mode == Strict || len(h.fields) == 0 {
+			return 0, false, ErrObsFold
+		}
+		value := cleanFieldValue(field)
+		last := &h.fields[len(h.fields)-1]
+		last.Value = strings.TrimSpace(last.Value + " " + string(value))
+		return consumedN, false, nil
+	}
+
 	parts := bytes.SplitN(field, []byte(":"), 2)
+	if len(parts) != 2 {
+		return 0, false, ErrMalformedFieldLine
+	}
 	fieldName := parts[0]
 	fieldValue := parts[1]
-	
-	if bytes.HasSuffix(fieldName, []byte(" ")) || len(fieldName) < 1 {
+
+	if mode == Lenient {
+		fieldName = bytes.TrimRight(fieldName, " \t")
+	}
+	if bytes.HasSuffix(fieldName, []byte(" ")) || bytes.HasSuffix(fieldName, []byte("\t")) || len(fieldName) < 1 {
 		return 0, false, ErrFieldNameContainsSpace
 	}
 
@@ -95,9 +280,29 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		}
 	}
 
-	fieldName, fieldValue = bytes.TrimSpace(fieldName), bytes.TrimSpace(fieldValue)
+	if bytes.ContainsAny(fieldValue, "\x00\r\n") {
+		if mode == Strict {
+			return 0, false, ErrBadFieldValue
+		}
+		fieldValue = cleanFieldValue(fieldValue)
+	}
+	fieldValue = bytes.Trim(fieldValue, " \t") // OWS
 
-	h.Set(string(fieldName), string(fieldValue))
+	// a plain append: Add copies the lines every time, which a long header
+	// section would turn quadratic
+	h.fields = append(h.fields, Field{Name: string(fieldName), Value: string(fieldValue)})
 
 	return consumedN, false, nil
 }
+
+// cleanFieldValue replaces NUL, CR and LF with SP and trims OWS.
+func cleanFieldValue(value []byte) []byte {
+	cleaned := make([]byte, len(value))
+	for i, c := range value {
+		if c == 0 || c == '\r' || c == '\n' {
+			c = ' '
+		}
+		cleaned[i] = c
+	}
+	return bytes.Trim(cleaned, " \t")
+}
@@ -2,6 +2,7 @@ package headers
 
 import (
 	"testing"
+	"time"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,4 +56,323 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, "tung, trilly, trillion", headers.Get("set-person"))
 	assert.Equal(t, 22, n)
 	assert.False(t, done)
+
+	// Test: field line without a colon
+	headers = NewHeaders()
+	data = []byte ("Host localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedFieldLine)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: obs-fold is rejected in strict mode
+	headers = NewHeaders()
+	data = []byte ("X-Folded: one\r\n  two\r\n\r\n")
+	n, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.ErrorIs(t, err, ErrObsFold)
+
+	// Test: obs-fold is unfolded in lenient mode
+	headers = NewHeaders()
+	n, _, err = headers.ParseWithMode(data, Lenient)
+	require.NoError(t, err)
+	_, _, err = headers.ParseWithMode(data[n:], Lenient)
+	require.NoError(t, err)
+	assert.Equal(t, "one two", headers.Get("x-folded"))
+
+	// Test: NUL, bare CR and bare LF in values
+	for _, value := range []string{"a\x00b", "a\rb", "a\nb"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte("X-Bad: " + value + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrBadFieldValue)
+
+		headers = NewHeaders()
+		_, _, err = headers.ParseWithMode([]byte("X-Bad: " + value + "\r\n\r\n"), Lenient)
+		require.NoError(t, err)
+		assert.Equal(t, "a b", headers.Get("x-bad"))
+	}
+
+	// Test: whitespace before the colon
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host\t: localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrFieldNameContainsSpace)
+
+	headers = NewHeaders()
+	_, _, err = headers.ParseWithMode([]byte("Host : localhost\r\n\r\n"), Lenient)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", headers.Get("host"))
+}
+
+func TestRange(t *testing.T) {
+	collect := func(h *Headers) []string {
+		var lines []string
+		h.Range(func(name string, value string) bool {
+			lines = append(lines, name+": "+value)
+			return true
+		})
+		return lines
+	}
+
+	// Test: Fields come out in the order they were set, with their casing
+	headers := NewHeaders()
+	headers.Add("Content-Type", "text/plain")
+	headers.Add("X-Request-ID", "42")
+	headers.Add("cache-control", "no-store")
+	headers.Add("x-request-id", "43")
+	assert.Equal(t, []string{"Content-Type: text/plain", "X-Request-ID: 42", "cache-control: no-store", "x-request-id: 43"}, collect(headers))
+
+	// Test: Replace keeps the position and casing of the first Set
+	headers.Replace("CONTENT-TYPE", "text/html")
+	assert.Equal(t, "Content-Type: text/html", collect(headers)[0])
+
+	// Test: Delete drops the field, and setting it again appends it
+	headers.Del("content-type")
+	headers.Add("Content-Type", "text/css")
+	assert.Equal(t, []string{"X-Request-ID: 42", "cache-control: no-store", "x-request-id: 43", "Content-Type: text/css"}, collect(headers))
+
+	// Test: Parsed fields keep the casing they were received with
+	headers = NewHeaders()
+	data := []byte("hOsT: localhost\r\nAccept: */*\r\n\r\n")
+	for done := false; !done; {
+		n, d, err := headers.Parse(data)
+		require.NoError(t, err)
+		data, done = data[n:], d
+	}
+	assert.Equal(t, []string{"hOsT: localhost", "Accept: */*"}, collect(headers))
+
+	// Test: Stopping early
+	var seen int
+	headers.Range(func(string, string) bool { seen++; return false })
+	assert.Equal(t, 1, seen)
+}
+
+func TestMultiValue(t *testing.T) {
+	// Test: Repeated fields stay separate lines, and Get joins them
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	headers.Add("Vary", "Accept")
+	headers.Add("set-cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT, b=2", headers.Get("Set-Cookie"))
+	assert.Nil(t, headers.Values("Cookie"))
+	assert.Equal(t, "", headers.Get("Cookie"))
+	assert.True(t, headers.Has("vary"))
+	assert.False(t, headers.Has("cookie"))
+	assert.Equal(t, 3, headers.Len())
+
+	// Test: Raw field lines
+	assert.Equal(t, []Field{
+		{Name: "Set-Cookie", Value: "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT"},
+		{Name: "Vary", Value: "Accept"},
+		{Name: "set-cookie", Value: "b=2"},
+	}, headers.Fields())
+
+	// Test: Replace collapses the lines into the first one
+	clone := headers.Clone()
+	headers.Replace("set-cookie", "c=3")
+	assert.Equal(t, []Field{{Name: "Set-Cookie", Value: "c=3"}, {Name: "Vary", Value: "Accept"}}, headers.Fields())
+
+	// Test: Del drops every line
+	headers.Del("Set-Cookie")
+	assert.Equal(t, []Field{{Name: "Vary", Value: "Accept"}}, headers.Fields())
+	headers.Del("Set-Cookie")
+	assert.Equal(t, 1, headers.Len())
+
+	// Test: The clone kept its own lines
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, clone.Values("set-cookie"))
+
+	// Test: A copy made by value does not see changes to the original
+	copied := *clone
+	clone.Add("X-Extra", "1")
+	clone.Replace("Vary", "Origin")
+	assert.False(t, copied.Has("X-Extra"))
+	assert.Equal(t, "Accept", copied.Get("Vary"))
+
+	// Test: Parsing keeps every line, with the casing it was received with
+	headers = NewHeaders()
+	data := []byte("Host: x\r\nset-cookie: a=1\r\nSet-Cookie: b=2\r\n\r\n")
+	for done := false; !done; {
+		n, d, err := headers.Parse(data)
+		require.NoError(t, err)
+		data, done = data[n:], d
+	}
+	assert.Equal(t, []Field{
+		{Name: "Host", Value: "x"},
+		{Name: "set-cookie", Value: "a=1"},
+		{Name: "Set-Cookie", Value: "b=2"},
+	}, headers.Fields())
+}
+
+func TestValidField(t *testing.T) {
+	assert.True(t, ValidFieldName("X-Custom_Header.1"))
+	assert.False(t, ValidFieldName(""))
+	assert.False(t, ValidFieldName("Bad Name"))
+	assert.False(t, ValidFieldName("X-Bad\r\nSet-Cookie"))
+
+	assert.True(t, ValidFieldValue("text/html; charset=utf-8\tand more"))
+	assert.True(t, ValidFieldValue(""))
+	assert.False(t, ValidFieldValue("x\r\nSet-Cookie: a=b"))
+	assert.False(t, ValidFieldValue("a\x00b"))
+	assert.False(t, ValidFieldValue("a\x7fb"))
+}
+
+func TestContentLength(t *testing.T) {
+	for value, want := range map[string]int64{"0": 0, "42": 42, "0042": 42, "9223372036854775807": 9223372036854775807} {
+		n, err := ParseContentLength(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, n, value)
+	}
+	for _, value := range []string{"", "-1", "+1", " 1", "1 ", "0x10", "1.0"} {
+		_, err := ParseContentLength(value)
+		assert.ErrorIs(t, err, ErrBadContentLength, value)
+	}
+	_, err := ParseContentLength("9223372036854775808")
+	assert.ErrorIs(t, err, ErrContentLengthRange)
+
+	// Test: Accessor
+	headers := NewHeaders()
+	n, err := headers.ContentLength(Strict)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), n)
+	headers.SetContentLength(1234)
+	assert.Equal(t, "1234", headers.Get("Content-Length"))
+	n, err = headers.ContentLength(Strict)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), n)
+
+	// Test: Repeated values are only accepted in lenient mode, and only
+	// when they agree
+	for _, repeated := range [][]string{{"1234", "1234"}, {"1234, 1234"}} {
+		headers = NewHeaders()
+		for _, v := range repeated {
+			headers.Add("Content-Length", v)
+		}
+		_, err = headers.ContentLength(Strict)
+		assert.ErrorIs(t, err, ErrConflictingContentLength)
+		n, err = headers.ContentLength(Lenient)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1234), n)
+	}
+	headers.Add("Content-Length", "5")
+	_, err = headers.ContentLength(Lenient)
+	assert.ErrorIs(t, err, ErrConflictingContentLength)
+
+	headers = NewHeaders()
+	headers.Add("Content-Length", "abc")
+	_, err = headers.ContentLength(Lenient)
+	assert.ErrorIs(t, err, ErrBadContentLength)
+}
+
+func TestMediaType(t *testing.T) {
+	m, err := ParseMediaType(`Multipart/Form-Data; Boundary="a;b,\"c"; charset=UTF-8`)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", m.Type)
+	assert.Equal(t, `a;b,"c`, m.Boundary())
+	assert.Equal(t, "UTF-8", m.Charset())
+	assert.Equal(t, `multipart/form-data; boundary="a;b,\"c"; charset=UTF-8`, FormatMediaType(m))
+
+	m, err = ParseMediaType("text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, MediaType{Type: "text/plain"}, m)
+	assert.Equal(t, "", m.Charset())
+
+	for _, value := range []string{"", "text", "text/", "/plain", "te xt/plain", "text/plain; charset", "text/plain; a=1; A=2", `text/plain; a="open`} {
+		_, err := ParseMediaType(value)
+		assert.ErrorIs(t, err, ErrBadMediaType, value)
+	}
+
+	// Test: Accessor
+	headers := NewHeaders()
+	m, err = headers.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "", m.Type)
+	headers.SetContentType(MediaType{Type: "text/html", Params: map[string]string{"charset": "utf-8"}})
+	assert.Equal(t, "text/html; charset=utf-8", headers.Get("content-type"))
+	m, err = headers.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", m.Charset())
+}
+
+func TestDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: IMF-fixdate and the obsolete formats (RFC 9110 5.6.7)
+	for _, value := range []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sunday, 06-Nov-94 08:49:37 GMT", "Sun Nov  6 08:49:37 1994"} {
+		d, err := ParseDate(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(d), value)
+	}
+	for _, value := range []string{"", "yesterday", "1994-11-06T08:49:37Z", "Sun, 06 Nov 1994 08:49:37 PST"} {
+		_, err := ParseDate(value)
+		assert.ErrorIs(t, err, ErrBadDate, value)
+	}
+
+	// Test: Always formatted as IMF-fixdate in GMT
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatDate(want.In(time.FixedZone("CET", 3600))))
+
+	// Test: Accessor ignores an invalid date
+	headers := NewHeaders()
+	headers.SetDate("Last-Modified", want)
+	d, ok := headers.Date("last-modified")
+	assert.True(t, ok)
+	assert.True(t, want.Equal(d))
+	headers.Add("If-Modified-Since", "not a date")
+	_, ok = headers.Date("If-Modified-Since")
+	assert.False(t, ok)
+	_, ok = headers.Date("Date")
+	assert.False(t, ok)
+}
+
+func TestWeightedList(t *testing.T) {
+	list, err := ParseWeightedList("text/html;level=1, application/json;q=0.9, */*;q=0, , gzip;q=1.000")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+		{Value: "application/json", Q: 0.9},
+		{Value: "*/*", Q: 0},
+		{Value: "gzip", Q: 1},
+	}, list)
+	assert.Equal(t, "text/html; level=1, application/json;q=0.9, */*;q=0", FormatWeightedList(list[:3]))
+
+	for _, value := range []string{"text/html;q=2", "text/html;q=0.1234", "text/html;q=1.5", "text/html;q=", ";q=1"} {
+		_, err := ParseWeightedList(value)
+		assert.Error(t, err, value)
+	}
+	_, err = ParseWeightedList("text/html;q=-1")
+	assert.ErrorIs(t, err, ErrBadWeight)
+
+	list, err = ParseWeightedList("")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestLists(t *testing.T) {
+	// Test: Token lists skip empty elements
+	tokens, err := ParseTokenList("keep-alive, , Upgrade,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"keep-alive", "Upgrade"}, tokens)
+	assert.Equal(t, "keep-alive, Upgrade", FormatTokenList(tokens))
+	_, err = ParseTokenList("close, not a token")
+	assert.ErrorIs(t, err, ErrBadList)
+
+	// Test: Connection options from every line, lowercased
+	headers := NewHeaders()
+	headers.Add("Connection", "Keep-Alive")
+	headers.Add("connection", "Upgrade")
+	options, err := headers.Connection()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"keep-alive", "upgrade"}, options)
+	headers.SetConnection("close")
+	assert.Equal(t, []Field{{Name: "Connection", Value: "close"}}, headers.Fields())
+
+	// Test: Quoted-string lists keep commas inside quotes
+	values, err := ParseQuotedStringList(`"a, b", plain, "say \"hi\"", "back\\slash"`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a, b", "plain", `say "hi"`, `back\slash`}, values)
+	assert.Equal(t, `"a, b", "plain", "say \"hi\"", "back\\slash"`, FormatQuotedStringList(values))
+	for _, value := range []string{`"unterminated`, `"a"b"`, `not a token`} {
+		_, err := ParseQuotedStringList(value)
+		assert.ErrorIs(t, err, ErrBadList, value)
+	}
 }
@@ -0,0 +1,149 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"https/internal/body"
+	"io"
+)
+
+// bodyReader streams a request body off the connection as the handler reads
+// it, stripping the framing with a body.Decoder. Only partial framing lines
+// are ever buffered, never the payload itself.
+type bodyReader struct {
+	buf    *buffer
+	dec    body.Decoder
+	limit  int // MaxBodyBytes, 0 for no limit
+	read   int // decoded bytes handed out so far
+	offset int // bytes of the request consumed so far, for ParseError
+	done   bool
+	closed bool
+	err    error // sticky
+	// sendContinue, if set, runs right before the first Read; see
+	// Request.SetContinueFunc
+	sendContinue func() error
+	// onDone, if set, runs once the whole body has been read; see
+	// RequestReader.WatchClose
+	onDone func()
+}
+
+var ErrBodyReadAfterClose = fmt.Errorf("read on closed body")
+var ErrBodyNotDrained = fmt.Errorf("unread body too large to drain")
+
+// maxDrainBytes is how much unread body Close will discard before giving up
+// on the connection.
+const maxDrainBytes = 256 << 10
+
+// NoBody is the Body of a request that has no content.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+func newBodyReader(buf *buffer, dec body.Decoder, limit int, offset int) *bodyReader {
+	return &bodyReader{
+		buf:    buf,
+		dec:    dec,
+		limit:  limit,
+		offset: offset,
+	}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyReadAfterClose
+	}
+	if send := b.sendContinue; send != nil {
+		b.sendContinue = nil
+		if err := send(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+	return b.readBody(p)
+}
+
+func (b *bodyReader) readBody(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	for !b.done {
+		w, n, done, err := b.dec.Decode(p, b.buf.bytes())
+		b.buf.discard(n)
+		b.read += w
+		if err != nil {
+			switch {
+			case errors.Is(err, body.ErrTooManyTrailers):
+				err = ErrTooManyHeaders
+			case errors.Is(err, body.ErrTrailersTooLarge):
+				err = ErrHeadersTooLarge
+			}
+			b.err = newParseError(err, b.offset)
+			return w, b.err
+		}
+		b.offset += n
+		b.done = done
+		if done && b.onDone != nil {
+			b.onDone()
+			b.onDone = nil
+		}
+		if b.limit > 0 && b.read > b.limit {
+			b.err = newParseError(ErrBodyTooLarge, b.offset)
+			return w, b.err
+		}
+		if w > 0 || len(p) == 0 {
+			return w, nil
+		}
+		if done || n > 0 { // only framing was consumed
+			continue
+		}
+		if err := b.buf.fill(); err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+				err = io.ErrUnexpectedEOF
+			case errors.Is(err, errBufferFull): // chunk-ext or trailer line
+				err = newParseError(ErrHeadersTooLarge, b.offset)
+			}
+			b.err = err
+			return 0, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// Close discards whatever the handler left unread so that the connection is
+// positioned at the next request. A non-nil error means the rest of the body
+// could not be drained and the connection must not be reused; calling Close
+// again reports the same error.
+//
+// A body still waiting on its "100 Continue" is never drained: the client
+// may hold it back indefinitely, so Close reports ErrBodyNotDrained.
+func (b *bodyReader) Close() error {
+	if !b.closed && b.sendContinue != nil {
+		b.closed = true
+		b.err = ErrBodyNotDrained
+	}
+	if !b.closed {
+		b.closed = true
+		b.drain()
+	}
+	if !b.done {
+		return b.err
+	}
+	return nil
+}
+
+func (b *bodyReader) drain() {
+	scratch := make([]byte, 4096)
+	drained := 0
+	for drained <= maxDrainBytes {
+		n, err := b.readBody(scratch)
+		drained += n
+		if err != nil {
+			return
+		}
+	}
+	b.err = ErrBodyNotDrained
+}
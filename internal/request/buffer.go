@@ -0,0 +1,74 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+// buffer holds bytes read from src that have not been consumed yet, either
+// by the request parser or by a body reader.
+type buffer struct {
+	src   io.Reader
+	buf   []byte
+	n     int // valid bytes currently in buf
+	limit int // largest len(buf) allowed, 0 for no limit
+}
+
+var errBufferFull = fmt.Errorf("read buffer full")
+
+// maxEmptyReads is how many (0, nil) reads fill tolerates before giving up.
+const maxEmptyReads = 100
+
+func newBuffer(src io.Reader, limit int) *buffer {
+	return &buffer{
+		src:   src,
+		buf:   make([]byte, 1024),
+		limit: limit,
+	}
+}
+
+func (b *buffer) bytes() []byte {
+	return b.buf[:b.n]
+}
+
+// discard drops the first n buffered bytes once they have been consumed.
+// buf[n:b.n] are still unparsed leftovers, so shift them left.
+func (b *buffer) discard(n int) {
+	copy(b.buf, b.buf[n:b.n])
+	b.n -= n
+}
+
+// fill reads at least one more byte from src. When buf is already full it
+// first doubles in size, returning errBufferFull once limit is reached.
+func (b *buffer) fill() error {
+	if b.n == len(b.buf) {
+		if err := b.grow(); err != nil {
+			return err
+		}
+	}
+	for range maxEmptyReads {
+		n, err := b.src.Read(b.buf[b.n:])
+		b.n += n
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
+}
+
+func (b *buffer) grow() error {
+	size := len(b.buf) * 2
+	if b.limit > 0 {
+		if len(b.buf) >= b.limit {
+			return errBufferFull
+		}
+		size = min(size, b.limit)
+	}
+	grown := make([]byte, size)
+	copy(grown, b.buf[:b.n])
+	b.buf = grown
+	return nil
+}
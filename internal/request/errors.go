@@ -0,0 +1,87 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies why a request could not be parsed.
+type ErrorKind int
+
+const (
+	KindMalformed          ErrorKind = iota // syntax the parser cannot accept
+	KindBodyTooLarge                        // Options.MaxBodyBytes
+	KindRequestLineTooLong                  // Options.MaxRequestLineBytes
+	KindHeadersTooLarge                     // Options.MaxHeaderBytes or MaxHeaderCount
+	KindNotImplemented                      // valid, but a feature this server lacks
+	KindUnsupportedVersion                  // not HTTP/1.0 or HTTP/1.1
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindMalformed:
+		return "malformed"
+	case KindBodyTooLarge:
+		return "body too large"
+	case KindRequestLineTooLong:
+		return "request-line too long"
+	case KindHeadersTooLarge:
+		return "headers too large"
+	case KindNotImplemented:
+		return "not implemented"
+	case KindUnsupportedVersion:
+		return "unsupported version"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// ParseError is returned for every request the parser rejects. Err is the
+// underlying sentinel (ErrBadRequestLine, headers.ErrBadFieldName, ...), so
+// errors.Is keeps working on a ParseError.
+type ParseError struct {
+	Kind ErrorKind
+	// Offset is how many bytes into the request the line that failed
+	// starts; for body errors it counts the framing bytes consumed so far.
+	Offset int
+	// StatusCode is the response status the server should reply with:
+	// 400, 413, 414, 431, 501 or 505.
+	StatusCode int
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at byte %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError classifies err, which must come from the parser itself and
+// not from the underlying reader.
+func newParseError(err error, offset int) *ParseError {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		return pe
+	}
+
+	kind, status := KindMalformed, 400
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		kind, status = KindBodyTooLarge, 413
+	case errors.Is(err, ErrRequestLineTooLong):
+		kind, status = KindRequestLineTooLong, 414
+	case errors.Is(err, ErrHeadersTooLarge), errors.Is(err, ErrTooManyHeaders):
+		kind, status = KindHeadersTooLarge, 431
+	case errors.Is(err, ErrUnsupportedTransferEncoding):
+		kind, status = KindNotImplemented, 501
+	case errors.Is(err, ErrUnsupportedVersion):
+		kind, status = KindUnsupportedVersion, 505
+	}
+	return &ParseError{
+		Kind:       kind,
+		Offset:     offset,
+		StatusCode: status,
+		Err:        err,
+	}
+}
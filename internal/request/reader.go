@@ -0,0 +1,183 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// RequestReader parses successive requests off a single connection. Bytes
+// read past the end of one request (a pipelined request, or the start of the
+// next one on a keep-alive connection) stay buffered for the next call to
+// ReadRequest.
+//
+// This type is NOT safe for concurrent use without external synchronization.
+type RequestReader struct {
+	buf  *buffer
+	opts Options
+	last *Request
+
+	mu       sync.Mutex // guards the fields below, see WatchClose
+	onClose  func()
+	watching chan struct{} // closed when the background read returns
+	stopped  bool
+}
+
+func NewRequestReader(reader io.Reader, opts Options) *RequestReader {
+	return &RequestReader{
+		buf:  newBuffer(reader, opts.maxBufferBytes()),
+		opts: opts,
+	}
+}
+
+// WaitForRequest blocks until the first byte of the next request has
+// arrived, so a server can time how long a connection sits idle separately
+// from how long the request itself takes. It returns io.EOF when the peer
+// closed the connection cleanly instead.
+func (rr *RequestReader) WaitForRequest() error {
+	if err := rr.closeLast(); err != nil {
+		return err
+	}
+	if len(rr.buf.bytes()) > 0 {
+		return nil
+	}
+	return rr.buf.fill()
+}
+
+func (rr *RequestReader) closeLast() error {
+	if rr.last == nil {
+		return nil
+	}
+	if err := rr.last.Body.Close(); err != nil {
+		return fmt.Errorf("previous request body: %w", err)
+	}
+	rr.last = nil
+	return nil
+}
+
+// ReadRequest returns the next request on the connection. A request the
+// parser rejects is reported as a *ParseError; any other error comes from
+// the underlying reader. Whatever is left
+// of the previous request's body is drained first, so handlers do not have
+// to read it. It returns io.EOF when the peer closed the connection cleanly
+// between requests.
+func (rr *RequestReader) ReadRequest() (*Request, error) {
+	if err := rr.closeLast(); err != nil {
+		return nil, err
+	}
+
+	r := NewRequest()
+	r.opts = rr.opts
+	r.state = StateParsingRequestLine
+	for {
+		// pass the valid bytes in buf to parse.
+		// parsedN is the number of bytes the request parsed (read)
+		parsedN, err := r.parse(rr.buf.bytes())
+		if err != nil {
+			return nil, err
+		}
+		rr.buf.discard(parsedN)
+		if r.headersDone() {
+			break
+		}
+
+		if err := rr.buf.fill(); err != nil {
+			switch {
+			case errors.Is(err, errBufferFull):
+				return nil, newParseError(r.limitError(), r.offset)
+			case errors.Is(err, io.EOF) && r.state == StateParsingRequestLine && len(rr.buf.bytes()) == 0:
+				return nil, io.EOF
+			case errors.Is(err, io.EOF):
+				return nil, fmt.Errorf("incomplete request, in state: %s: %w", r.state, io.ErrUnexpectedEOF)
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	if err := r.validateHeaders(); err != nil {
+		return nil, newParseError(err, r.offset)
+	}
+	if err := r.setupBody(rr.buf); err != nil {
+		return nil, newParseError(err, r.offset)
+	}
+	rr.last = r
+	return r, nil
+}
+
+// WatchClose arranges for onClose to be called if the client closes the
+// connection while the last request returned by ReadRequest is being
+// handled, so the handler can stop early. Until the request body has been
+// read in full the connection cannot be watched without stealing body
+// bytes, so watching starts only then. Bytes of a pipelined request that
+// arrive in the meantime stay buffered for the next ReadRequest.
+//
+// Every WatchClose must be followed by a StopWatching before the reader is
+// used again.
+func (rr *RequestReader) WatchClose(onClose func()) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.onClose = onClose
+	rr.stopped = false
+
+	if b, ok := rr.last.Body.(*bodyReader); ok && !b.done {
+		b.onDone = func() {
+			rr.mu.Lock()
+			defer rr.mu.Unlock()
+			rr.startWatching()
+		}
+		return
+	}
+	rr.startWatching()
+}
+
+// startWatching reads ahead in the background until data arrives, the
+// connection fails, the read deadline passes or StopWatching interrupts it.
+// Only EOF or an error other than a timeout counts as a close. rr.mu must be
+// held.
+func (rr *RequestReader) startWatching() {
+	if rr.stopped || rr.watching != nil {
+		return
+	}
+	done := make(chan struct{})
+	rr.watching = done
+	go func() {
+		defer close(done)
+		err := rr.buf.fill()
+		if err == nil || errors.Is(err, errBufferFull) {
+			return // the next request, not a close
+		}
+		// a read deadline running out says nothing about the client, which
+		// may well be waiting for a slow handler
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return
+		}
+		rr.mu.Lock()
+		stopped, onClose := rr.stopped, rr.onClose
+		rr.mu.Unlock()
+		if !stopped && onClose != nil {
+			onClose()
+		}
+	}()
+}
+
+// StopWatching ends a WatchClose. If the background read is still blocked,
+// interrupt must unblock it, e.g. by setting a read deadline in the past;
+// StopWatching waits for it to return, so the caller has to reset the
+// deadline afterwards.
+func (rr *RequestReader) StopWatching(interrupt func()) {
+	rr.mu.Lock()
+	rr.stopped = true
+	done := rr.watching
+	rr.watching = nil
+	rr.onClose = nil
+	rr.mu.Unlock()
+
+	if done != nil {
+		interrupt()
+		<-done
+	}
+}
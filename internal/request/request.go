@@ -1,19 +1,21 @@
 package request
 
 import (
+	"context"
 	"bytes"
 	"errors"
 	"fmt"
 	"https/internal/body"
 	"https/internal/headers"
 	"io"
+	"math"
 	"strings"
 	"unicode"
-	"strconv"
 )
 
+// ValidHTTP reports whether this parser speaks the request's HTTP version.
 func (r *RequestLine) ValidHTTP() bool {
-	return r.HTTPVersion == "1.1"
+	return r.HTTPVersion == "1.1" || r.HTTPVersion == "1.0"
 }
 
 func (r *RequestLine) ValidMethod() bool {
@@ -46,22 +48,83 @@ type RequestLine struct {
 
 type Request struct {
 	RequestLine RequestLine // Ex: GET /coffee HTTP/1.1
+	Target *Target // RequestLine.RequestTarget, parsed
 	Headers *headers.Headers
-	Body *body.Body
+	// Body streams the payload from the connection as it is read. It is never
+	// nil; requests without content get NoBody.
+	Body io.ReadCloser
+	ContentLength int // -1 when the length is not known up front (chunked)
+	// Trailers is only set for Transfer-Encoding: chunked and is complete
+	// once Body has returned io.EOF.
+	Trailers *headers.Headers
+	ctx context.Context // nil means context.Background()
+	pathValues map[string]string // set by a router, see PathValue
 	state parserState
+	opts Options
+	headerBytes int // bytes of field lines consumed so far
+	headerCount int
+	offset int // bytes of this request consumed so far, for ParseError
 }
 
 func NewRequest() *Request {
 	return &Request {
 		state: StateInitialized,
-		Body: body.NewBody(),
+		Body: NoBody,
+		opts: DefaultOptions(),
 	}
 }
 
+// Options bounds how much of a request the parser will accept. A zero value
+// for any limit means that limit is not enforced.
+type Options struct {
+	MaxRequestLineBytes int // request-line, excluding CRLF
+	MaxHeaderBytes      int // all field lines, including CRLFs
+	MaxHeaderCount      int
+	MaxBodyBytes        int // decoded body
+	// Lenient repairs ambiguous framing and field lines instead of rejecting
+	// them (see headers.Lenient). Never enable it for a server that sits
+	// behind a proxy or CDN; it exists for debugging tools.
+	Lenient bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxRequestLineBytes: 8 << 10,
+		MaxHeaderBytes:      64 << 10,
+		MaxHeaderCount:      100,
+		MaxBodyBytes:        10 << 20,
+	}
+}
+
+func (o Options) headerMode() headers.ParseMode {
+	if o.Lenient {
+		return headers.Lenient
+	}
+	return headers.Strict
+}
+
+// maxBufferBytes is how large the read buffer may grow while waiting for the
+// end of a line. Body bytes are streamed to the handler, so they never
+// accumulate in the buffer.
+func (o Options) maxBufferBytes() int {
+	if o.MaxRequestLineBytes == 0 || o.MaxHeaderBytes == 0 {
+		return 0
+	}
+	return max(o.MaxRequestLineBytes+len(SEPARATOR), o.MaxHeaderBytes)
+}
+
 var ErrBadRequestLine = fmt.Errorf("bad request-line")
 var ErrIncompleteRequestLine = fmt.Errorf("incomplete start line")
 var ErrUnsupportedVersion = fmt.Errorf("unsupported HTTP version")
+var ErrMissingHost = fmt.Errorf("missing or repeated Host header")
 var ErrInvalidMethod = fmt.Errorf("invalid method")
+var ErrUnsupportedTransferEncoding = fmt.Errorf("unsupported transfer-encoding")
+var ErrRequestLineTooLong = fmt.Errorf("request-line too long")
+var ErrHeadersTooLarge = fmt.Errorf("header section too large")
+var ErrTooManyHeaders = fmt.Errorf("too many header fields")
+var ErrBodyTooLarge = fmt.Errorf("body too large")
+var ErrBadContentLength = fmt.Errorf("bad Content-Length")
+var ErrAmbiguousFraming = fmt.Errorf("ambiguous message framing")
 var SEPARATOR = []byte("\r\n")
 
 func parseRequestLine(b []byte) (*RequestLine, int, error) {
@@ -79,12 +142,16 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 		return nil, consumedN, ErrIncompleteRequestLine// Empty
 	}
 
-	versionParts := bytes.Split(requestLineParts[2], []byte("/"))
+	// HTTP-version = "HTTP" "/" DIGIT "." DIGIT
+	version, ok := bytes.CutPrefix(requestLineParts[2], []byte("HTTP/"))
+	if !ok || len(version) != 3 || version[1] != '.' || !isDigit(version[0]) || !isDigit(version[2]) {
+		return nil, consumedN, ErrBadRequestLine
+	}
 
 	requestLine := &RequestLine{
 		Method: string(requestLineParts[0]),
 		RequestTarget: string(requestLineParts[1]),
-		HTTPVersion: string(versionParts[1]),
+		HTTPVersion: string(version),
 	}
 
 	if !requestLine.ValidHTTP() {
@@ -105,57 +172,245 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		lineLen := n - len(SEPARATOR)
+		if n == 0 { // a partial line is still buffered
+			lineLen = len(data)
+		}
+		if limit := r.opts.MaxRequestLineBytes; limit > 0 && lineLen > limit {
+			return 0, ErrRequestLineTooLong
+		}
 		if n == 0 { // need more data
 			return 0, nil
 		}
+		target, err := ParseTarget(rl.Method, rl.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *rl 
+		r.Target = target
 		r.state = StateParsingHeaders
 		return n, nil
 	case StateParsingHeaders:
 		if r.Headers == nil {
 			r.Headers = headers.NewHeaders()
 		}
-		n, isHeaderDone, err := r.Headers.Parse(data)
+		n, isHeaderDone, err := r.Headers.ParseWithMode(data, r.opts.headerMode())
 		if err != nil {
 			return 0, err
 		}
+		if err := r.checkHeaderLimits(n, isHeaderDone, len(data)); err != nil {
+			return 0, err
+		}
 		if isHeaderDone {
 				fmt.Println("header done")
 				r.state = StateParsingBody
 				return n, nil
 		}
 		return n, nil
-	case StateParsingBody:
-		cl := r.Headers.Get("content-length") 
-		length := 0
-		if cl == "" { // nothing in body to parse
-			r.state = StateDone
-			return 0, nil
-		} 
-		length, err := strconv.Atoi(cl)
-		if err != nil {return 0, fmt.Errorf("error when trying to convert contentlength to int")}
-		r.Body.SetLength(length)
-		n, isDone, err := r.Body.Parse(data)	
-		if err != nil {return 0, fmt.Errorf("error when parsing the body")}
-		if isDone {
-			r.state = StateDone
-			return n, nil
-		}
-		return n, nil
+	case StateParsingBody: // the body is streamed by bodyReader
+		return 0, nil
 	case StateDone:
 		return 0, fmt.Errorf("error trying to read in done state")
 	}
 	return 0, fmt.Errorf("unknown state")
 }
 
+// Context returns the request's context. A server cancels it when the
+// client goes away, when the server is shut down or when the handler runs
+// out of time; a handler should stop working on the request once it is
+// done. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// e.g. to attach a request ID for the handlers further down. The copy shares
+// Body with r.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// PathValue returns the value a router matched for the named parameter of
+// the route pattern, e.g. "42" for "{id}" in "/users/{id}", or "" if the
+// pattern has no such parameter.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets what PathValue returns for name.
+func (r *Request) SetPathValue(name string, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+	r.pathValues[name] = value
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue" and
+// is waiting for an interim response before it sends the body. HTTP/1.0
+// clients cannot ask for one (RFC 9110 10.1.1).
+func (r *Request) ExpectsContinue() bool {
+	return r.RequestLine.HTTPVersion != "1.0" && strings.EqualFold(r.Headers.Get("expect"), "100-continue")
+}
+
+// SetContinueFunc arranges for send to be called right before Body is first
+// read, so a server answers "Expect: 100-continue" only once the handler
+// actually wants the body. A handler that responds without reading the body
+// never triggers it. It has no effect on a request without a body.
+func (r *Request) SetContinueFunc(send func() error) {
+	if b, ok := r.Body.(*bodyReader); ok {
+		b.sendContinue = send
+	}
+}
+
+// validateHeaders checks the header section as a whole. HTTP/1.1 requests
+// must carry exactly one Host (RFC 9112 3.2); HTTP/1.0 predates it.
+func (r *Request) validateHeaders() error {
+	hosts := r.Headers.Values("host")
+	if len(hosts) > 1 {
+		return ErrMissingHost
+	}
+	if r.RequestLine.HTTPVersion == "1.1" && (len(hosts) == 0 || hosts[0] == "") {
+		return ErrMissingHost
+	}
+	return nil
+}
+
+// setupBody picks the body framing from the headers once they are complete
+// and hooks Body up to buf, following RFC 9112 6.3. A request that frames
+// its body in more than one way is rejected unless opts.Lenient is set, in
+// which case Transfer-Encoding wins as the RFC prescribes.
+func (r *Request) setupBody(buf *buffer) error {
+	hasTE := r.Headers.Has("transfer-encoding")
+	hasCL := r.Headers.Has("content-length")
+
+	if hasTE {
+		if hasCL && !r.opts.Lenient {
+			return ErrAmbiguousFraming
+		}
+		// HTTP/1.0 has no chunked coding, so an HTTP/1.0 hop in front of us
+		// will have framed this request differently (RFC 9112 6.1)
+		if r.RequestLine.HTTPVersion == "1.0" && !r.opts.Lenient {
+			return ErrAmbiguousFraming
+		}
+		if err := checkTransferEncoding(r.Headers.Get("transfer-encoding")); err != nil {
+			return err
+		}
+		chunked := body.NewChunked()
+		// the trailer section is a second header section and gets the same
+		// limits
+		chunked.SetTrailerLimits(r.opts.MaxHeaderCount, r.opts.MaxHeaderBytes)
+		r.Trailers = chunked.Trailers
+		r.ContentLength = -1
+		r.Body = newBodyReader(buf, chunked, r.opts.MaxBodyBytes, r.offset)
+		return nil
+	}
+
+	if !hasCL { // nothing in body to parse
+		r.state = StateDone
+		return nil
+	}
+	length, err := r.contentLength()
+	if err != nil {
+		return err
+	}
+	if r.opts.MaxBodyBytes > 0 && length > r.opts.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+	r.ContentLength = length
+	if length == 0 {
+		r.state = StateDone
+		return nil
+	}
+	fixed := body.NewBody()
+	fixed.SetLength(length)
+	r.Body = newBodyReader(buf, fixed, r.opts.MaxBodyBytes, r.offset)
+	return nil
+}
+
+// checkTransferEncoding accepts chunked, applied exactly once, as the only
+// transfer-coding. The body decoder only removes chunked, so any other
+// coding would reach the handler still applied; RFC 9112 6.1 has a server
+// answer codings it does not understand with 501.
+func checkTransferEncoding(te string) error {
+	chunkedCount := 0
+	for _, coding := range strings.Split(te, ",") {
+		coding = strings.TrimSpace(coding)
+		switch {
+		case coding == "": // empty list elements do not count (RFC 9110 5.6.1)
+		case strings.EqualFold(coding, "chunked"):
+			chunkedCount++
+		default:
+			return ErrUnsupportedTransferEncoding
+		}
+	}
+	if chunkedCount == 0 {
+		return ErrUnsupportedTransferEncoding
+	}
+	if chunkedCount > 1 {
+		return ErrAmbiguousFraming
+	}
+	return nil
+}
+
+// contentLength reads the Content-Length with Headers.ContentLength, in the
+// parse mode of the request, so that requests and responses judge the field
+// by the same rules.
+func (r *Request) contentLength() (int, error) {
+	length, err := r.Headers.ContentLength(r.opts.headerMode())
+	switch {
+	case errors.Is(err, headers.ErrContentLengthRange), length > math.MaxInt:
+		return 0, ErrBodyTooLarge
+	case errors.Is(err, headers.ErrConflictingContentLength):
+		return 0, ErrAmbiguousFraming
+	case err != nil:
+		return 0, ErrBadContentLength
+	}
+	return int(length), nil
+}
+
+// checkHeaderLimits accounts for a field line of n bytes that was just
+// consumed out of available buffered bytes.
+func (r *Request) checkHeaderLimits(n int, done bool, available int) error {
+	if n > 0 && !done {
+		r.headerCount++
+	}
+	r.headerBytes += n
+	if limit := r.opts.MaxHeaderCount; limit > 0 && r.headerCount > limit {
+		return ErrTooManyHeaders
+	}
+	pending := r.headerBytes
+	if n == 0 { // a partial line is still buffered
+		pending += available
+	}
+	if limit := r.opts.MaxHeaderBytes; limit > 0 && pending > limit {
+		return ErrHeadersTooLarge
+	}
+	return nil
+}
+
+// headersDone reports whether the request-line and header section are in.
+func (r *Request) headersDone() bool {
+	return r.state == StateParsingBody || r.state == StateDone
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	parsedN := 0
-	for r.state != StateDone {
+	for !r.headersDone() {
 		n, err := r.parseSingle(data[parsedN:])
 		if err != nil {
-			return 0, err
+			return 0, newParseError(err, r.offset)
 		}
 		parsedN += n
+		r.offset += n
 		if n == 0 {
 			break
 		}
@@ -164,70 +419,28 @@ func (r *Request) parse(data []byte) (int, error) {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	r := NewRequest()
-
-	// buf could overrun when header + body > 1k 
-	buf := make([]byte, 1024)
-	bufLen := 0 // valid bytes currently in the buffer
-
-	r.state = StateParsingRequestLine
-	for r.state != StateDone {
-		// Read and append to buf at right side of buf[bufLen] 
-		// buf[bufLen:] is the remaining free space of the buffer
-		n, err := reader.Read(buf[bufLen:]) 
-		if n > 0 {
-			bufLen += n 
-			// pass the bufLen of valid bytes in buf to parse.
-			// parseN is the number of bytes the request parsed (read)
-			parsedN, parseErr := r.parse(buf[:bufLen])
-			if parseErr != nil {
-				return nil, parseErr
-			}
-			copy(buf, buf[parsedN:bufLen])
-			bufLen -= parsedN 	
-			// data from bufLen up to parsedN is already consumed, so no longer need 0<----bufLen and update bufLen
-			// buf[parsedN:bufLen] are still unparsed leftovers
-			// shift left (use big brain)
-		}
-		if err == nil {
-			continue
-		}
-
-		switch {
-		case errors.Is(err, io.EOF):
-				// drain buffer
-				if (bufLen > 0 && r.state != StateDone) {
-					parseN, pErr := drainAndParse(r, buf[:bufLen])
-					if pErr != nil {
-						return nil, err
-					}
-					copy(buf, buf[parseN:bufLen])
-					bufLen -= parseN
-					// after this r.state should be done, else err
-				}
-
-				if r.state != StateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %s, read n bytes on EOF: %d", r.state, n)
-				}	
-				
-		default:
-			return nil, err		
-			}
-		}	
-	return r, nil
+	return RequestFromReaderWithOptions(reader, DefaultOptions())
 }
 
-// last drain
-func drainAndParse(r *Request, data []byte) (int, error) {
-	parseN, pErr := r.parse(data)
-	if pErr != nil {
-		return 0, pErr
+// RequestFromReaderWithOptions is RequestFromReader with explicit limits.
+// Exceeding a limit returns a *ParseError wrapping ErrRequestLineTooLong,
+// ErrHeadersTooLarge, ErrTooManyHeaders or ErrBodyTooLarge.
+//
+// It returns as soon as the header section has been parsed; the body is
+// read from reader on demand through Request.Body. Use a RequestReader to
+// read more than one request from the same reader.
+func RequestFromReaderWithOptions(reader io.Reader, opts Options) (*Request, error) {
+	r, err := NewRequestReader(reader, opts).ReadRequest()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("incomplete request, in state: %s: %w", StateParsingRequestLine, io.ErrUnexpectedEOF)
 	}
+	return r, err
+}
 
-	if len(r.Body.Body) != r.Body.ContentLength {
-		return 0, fmt.Errorf("body's len does not match content length %d != %d",
-			len(r.Body.Body), r.Body.ContentLength)
+// limitError is returned when a single line fills the largest buffer allowed.
+func (r *Request) limitError() error {
+	if r.state == StateParsingRequestLine {
+		return ErrRequestLineTooLong
 	}
-	r.state = StateDone
-	return parseN, nil
+	return ErrHeadersTooLarge
 }
@@ -0,0 +1,326 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is which of the four request-target forms (RFC 9112 3.2) a
+// request-line used.
+type TargetForm int
+
+const (
+	OriginForm    TargetForm = iota // /where?q=now
+	AbsoluteForm                    // http://www.example.org/where?q=now
+	AuthorityForm                   // www.example.com:80, CONNECT only
+	AsteriskForm                    // *, OPTIONS only
+)
+
+// Target is a parsed request-target.
+//
+// Path is percent-decoded and has its dot-segments removed, so it can be
+// compared against routes directly. RawPath is the same path still
+// percent-encoded (with unreserved characters decoded and dot-segments
+// removed), for callers that need to tell "/a%2Fb" from "/a/b" or forward
+// the path upstream unchanged.
+type Target struct {
+	Form     TargetForm
+	Scheme   string // absolute-form only, lowercased
+	Host     string // host[:port]; absolute-form and authority-form only
+	Path     string // empty for asterisk-form and authority-form
+	RawPath  string
+	RawQuery string // without the leading '?'
+	Query    Query
+}
+
+// Query holds the decoded query parameters of a request-target. A key may be
+// repeated, e.g. "?tag=a&tag=b".
+type Query map[string][]string
+
+// Get returns the first value for key, or "" if it is not present.
+func (q Query) Get(key string) string {
+	if vs := q[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Values returns every value for key in the order they appeared.
+func (q Query) Values(key string) []string {
+	return q[key]
+}
+
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+var ErrBadRequestTarget = fmt.Errorf("bad request-target")
+
+// ParseTarget parses the request-target of a request-line. method decides
+// which forms are allowed: authority-form only for CONNECT, asterisk-form
+// only for OPTIONS.
+func ParseTarget(method string, raw string) (*Target, error) {
+	if raw == "" || strings.ContainsAny(raw, "#") {
+		return nil, ErrBadRequestTarget
+	}
+
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(raw)
+	case raw == "*":
+		if method != "OPTIONS" {
+			return nil, ErrBadRequestTarget
+		}
+		return &Target{Form: AsteriskForm, Query: Query{}}, nil
+	case strings.HasPrefix(raw, "/"):
+		return parseOriginForm(raw, &Target{Form: OriginForm})
+	}
+	return parseAbsoluteForm(raw)
+}
+
+// authority-form = uri-host ":" port
+func parseAuthorityForm(raw string) (*Target, error) {
+	idx := strings.LastIndexByte(raw, ':')
+	if idx == -1 || !validHost(raw[:idx]) || !validPort(raw[idx+1:]) {
+		return nil, ErrBadRequestTarget
+	}
+	return &Target{Form: AuthorityForm, Host: raw, Query: Query{}}, nil
+}
+
+// absolute-form = scheme "://" authority path-abempty [ "?" query ]
+func parseAbsoluteForm(raw string) (*Target, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !validScheme(scheme) {
+		return nil, ErrBadRequestTarget
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if strings.Contains(host, "@") || !validAuthority(host) { // no userinfo (RFC 9110 4.2.4)
+		return nil, ErrBadRequestTarget
+	}
+
+	pathAndQuery := rest[end:]
+	if !strings.HasPrefix(pathAndQuery, "/") {
+		pathAndQuery = "/" + pathAndQuery
+	}
+	t := &Target{
+		Form:   AbsoluteForm,
+		Scheme: strings.ToLower(scheme),
+		Host:   strings.ToLower(host),
+	}
+	return parseOriginForm(pathAndQuery, t)
+}
+
+// origin-form = absolute-path [ "?" query ]
+func parseOriginForm(raw string, t *Target) (*Target, error) {
+	rawPath, rawQuery, _ := strings.Cut(raw, "?")
+	if !validChars(rawPath, "/") || !validChars(rawQuery, "/?") {
+		return nil, ErrBadRequestTarget
+	}
+
+	normalized, err := normalizePercent(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	t.RawPath = removeDotSegments(normalized)
+
+	path, err := unescape(t.RawPath, false)
+	if err != nil {
+		return nil, err
+	}
+	// an encoded "/" or NUL must not smuggle a dot-segment or a string
+	// terminator past the normalization above
+	if strings.ContainsRune(path, 0) || removeDotSegments(path) != path {
+		return nil, ErrBadRequestTarget
+	}
+	t.Path = path
+
+	t.RawQuery = rawQuery
+	t.Query, err = parseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseQuery(rawQuery string) (Query, error) {
+	q := Query{}
+	if rawQuery == "" {
+		return q, nil
+	}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return nil, err
+		}
+		q[key] = append(q[key], value)
+	}
+	return q, nil
+}
+
+// removeDotSegments implements RFC 3986 5.2.4 on an absolute path. A ".."
+// at the root is dropped, so the result never climbs above "/".
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")[1:]
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// normalizePercent decodes percent-encoded unreserved characters and
+// uppercases the hex digits of the rest (RFC 3986 6.2.2), so "%2e%2E" is
+// seen as ".." by removeDotSegments.
+func normalizePercent(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", ErrBadRequestTarget
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// unescape percent-decodes s, and for query components also turns '+' into
+// a space as HTML forms expect.
+func unescape(s string, query bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", ErrBadRequestTarget
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && query:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// validChars reports whether s only holds pchar (RFC 3986 3.3) plus extra.
+func validChars(s string, extra string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) || strings.IndexByte("%!$&'()*+,;=:@", c) != -1 || strings.IndexByte(extra, c) != -1 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func validScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isAlpha(s[i]) && !isDigit(s[i]) && strings.IndexByte("+-.", s[i]) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// validAuthority accepts host [ ":" port ] with an optional empty port.
+func validAuthority(s string) bool {
+	if idx := strings.LastIndexByte(s, ':'); idx != -1 && !strings.HasSuffix(s, "]") {
+		port := s[idx+1:]
+		return validHost(s[:idx]) && (port == "" || validPort(port))
+	}
+	return validHost(s)
+}
+
+func validHost(s string) bool {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") { // IP-literal
+		return len(s) > 2 && validChars(s[1:len(s)-1], "") && !strings.Contains(s, "%")
+	}
+	return s != "" && validChars(s, "") && !strings.ContainsAny(s, ":@")
+}
+
+func validPort(s string) bool {
+	if s == "" || len(s) > 5 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isUnreserved(c byte) bool {
+	return isAlpha(c) || isDigit(c) || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
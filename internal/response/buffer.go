@@ -0,0 +1,88 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// writeBufferBytes is the size of the buffers behind NewBufferedWriter:
+// enough for a typical header section plus a few chunks in one write.
+const writeBufferBytes = 4096
+
+// bufferPool holds the *bufio.Writer of released writers, so a busy server
+// does not allocate a new buffer for every response.
+var bufferPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, writeBufferBytes)
+	},
+}
+
+// Flusher is implemented by writers that can send buffered output on
+// demand, for handlers that stream a response and want each part to reach
+// the client as soon as it is written.
+type Flusher interface {
+	Flush() error
+}
+
+var ErrWriterReleased = fmt.Errorf("write on released response writer")
+
+// NewBufferedWriter returns a Writer that collects the status-line, the
+// header lines and the body in a pooled buffer, and writes them to writer
+// in as few calls as possible: when the buffer fills up, on Flush and on
+// Finish. Call Release once the response is complete.
+func NewBufferedWriter(writer io.Writer) *Writer {
+	buffered := bufferPool.Get().(*bufio.Writer)
+	buffered.Reset(writer)
+	w := NewWriter(buffered)
+	w.buffered = buffered
+	return w
+}
+
+// Flush sends everything written so far to the client. With the Header and
+// Write API this also commits the header section, picking chunked framing
+// (or, for an HTTP/1.0 client, closing the connection) unless a
+// Content-Length was declared, since the rest of the body is still to come.
+// Flush does nothing more for a Writer that is not buffered.
+func (w *Writer) Flush() error {
+	if w.writerState == writerStateStatusLine {
+		w.WriteHeader(StatusOk)
+		if err := w.commit(false); err != nil {
+			return err
+		}
+	}
+	return w.flushBuffer()
+}
+
+func (w *Writer) flushBuffer() error {
+	if w.buffered == nil {
+		return nil
+	}
+	if err := w.buffered.Flush(); err != nil {
+		return fmt.Errorf("error when flushing response: %w", err)
+	}
+	return nil
+}
+
+// Release flushes the buffer of a writer from NewBufferedWriter and returns
+// it to the pool. The Writer must not be used afterwards: a handler that
+// keeps writing after it returned gets ErrWriterReleased instead of
+// scribbling over another response.
+func (w *Writer) Release() error {
+	if w.buffered == nil {
+		return nil
+	}
+	err := w.flushBuffer()
+	w.buffered.Reset(nil)
+	bufferPool.Put(w.buffered)
+	w.buffered = nil
+	w.writer = releasedWriter{}
+	return err
+}
+
+type releasedWriter struct{}
+
+func (releasedWriter) Write([]byte) (int, error) {
+	return 0, ErrWriterReleased
+}
@@ -0,0 +1,158 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"https/internal/headers"
+	"strings"
+)
+
+// Problem describes an error response, following the problem details
+// object of RFC 9457.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. Empty means
+	// "about:blank": the problem is just what the status says.
+	Type string `json:"type"`
+	// Title is a short summary of the kind of problem. Empty means the
+	// reason phrase of Status.
+	Title  string     `json:"title"`
+	Status StatusCode `json:"status"`
+	// Detail explains this occurrence of the problem to the client.
+	Detail string `json:"detail,omitempty"`
+	// Instance is a URI identifying this occurrence, e.g. the request path.
+	Instance string `json:"instance,omitempty"`
+}
+
+const (
+	problemJSONType = "application/problem+json"
+	htmlType        = "text/html"
+)
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<html>
+	<head>
+	<title>{{.Status}} {{.Title}}</title>
+	</head>
+	<body>
+	<h1>{{.Title}}</h1>
+	<p>{{.Detail}}</p>
+	</body>
+	</html>`))
+
+// ErrorPages writes error responses in the format the client asked for in
+// its Accept header: problem+json for API clients, an HTML page from a
+// template otherwise. Templates are executed with the Problem as data.
+//
+// Register every template before serving; ErrorPages is not safe for
+// concurrent registration.
+type ErrorPages struct {
+	templates map[StatusCode]*template.Template
+	fallback  *template.Template
+}
+
+func NewErrorPages() *ErrorPages {
+	return &ErrorPages{
+		templates: map[StatusCode]*template.Template{},
+		fallback:  defaultErrorTemplate,
+	}
+}
+
+// SetTemplate sets the HTML page for one status code.
+func (p *ErrorPages) SetTemplate(statusCode StatusCode, tmpl *template.Template) {
+	p.templates[statusCode] = tmpl
+}
+
+// SetDefaultTemplate sets the HTML page for status codes without a template
+// of their own.
+func (p *ErrorPages) SetDefaultTemplate(tmpl *template.Template) {
+	p.fallback = tmpl
+}
+
+// Write sends problem as a complete response. accept is the Accept header
+// of the request.
+func (p *ErrorPages) Write(w *Writer, accept string, problem Problem) error {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = StatusText(problem.Status)
+	}
+
+	var body []byte
+	contentType := problemJSONType
+	if prefersJSON(accept) {
+		var err error
+		if body, err = json.Marshal(problem); err != nil {
+			return fmt.Errorf("error when encoding problem: %w", err)
+		}
+	} else {
+		tmpl, ok := p.templates[problem.Status]
+		if !ok {
+			tmpl = p.fallback
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, problem); err != nil {
+			return fmt.Errorf("error when rendering error page: %w", err)
+		}
+		body = buf.Bytes()
+		contentType = htmlType
+	}
+
+	h := GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	h.Replace("Vary", "Accept")
+	if err := w.WriteStatusLine(problem.Status); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
+
+// prefersJSON reports whether an Accept header ranks problem+json above
+// HTML (RFC 9110 12.5.1). "application/json" counts for problem+json, since
+// that is what JSON API clients send. A tie, including no Accept header at
+// all, goes to HTML, which is what browsers expect.
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, problemJSONType, "application/json") > acceptQuality(accept, htmlType)
+}
+
+// acceptQuality returns the weight accept gives the first of mediaTypes,
+// with the others treated as equally specific aliases for it: the weight
+// of the most specific media range matching any of them. A malformed Accept
+// header counts as none at all.
+func acceptQuality(accept string, mediaTypes ...string) float64 {
+	ranges, err := headers.ParseWeightedList(accept)
+	if err != nil || len(ranges) == 0 {
+		return 1
+	}
+	quality, specificity := 0.0, -1
+	for _, r := range ranges {
+		mediaRange := strings.ToLower(r.Value)
+		for _, mediaType := range mediaTypes {
+			s := rangeSpecificity(mediaRange, mediaType)
+			if s > specificity {
+				quality, specificity = r.Q, s
+			}
+		}
+	}
+	return quality
+}
+
+// rangeSpecificity returns how specifically mediaRange matches mediaType:
+// 2 for the type itself, 1 for "type/*", 0 for "*/*" and -1 for no match.
+func rangeSpecificity(mediaRange string, mediaType string) int {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	switch mediaRange {
+	case mediaType:
+		return 2
+	case typ + "/*":
+		return 1
+	case "*/*":
+		return 0
+	}
+	return -1
+}
@@ -7,10 +7,12 @@ TODO: Consider these as well
     Cache-Control: Directives for caching mechanisms in both requests and responses. This is useful for telling the client or any intermediaries how to cache the response.
 */
 import (
+	"bufio"
 	"fmt"
 	"https/internal/headers"
 	"io"
-	"strconv"
+	"math"
+	"strings"
 )
 
 type writerState int
@@ -19,19 +21,50 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
-)
-
-type StatusCode int
-const (
-	StatusOk StatusCode = 200
-	StatusBadRequest StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	writerStateDone
 )
 
 type Writer struct {
 	writer io.Writer	
 	writerState writerState
 	BodyResponse []byte
+	keepAlive bool
+	chunked bool
+	httpVersion string // of the request, "1.1" or "1.0"
+	unchunked bool // chunks are written as-is for an HTTP/1.0 client
+	bodyless bool // the status does not allow content, or the request was HEAD
+	head bool // the request was HEAD, see SetRequestMethod
+	hooks []Hook // most recently added first
+	statusCode StatusCode
+	written int
+	contentLength int // declared by the header section, -1 if none
+
+	buffered *bufio.Writer // set by NewBufferedWriter, see Flush
+
+	// state of the Header/WriteHeader/Write API, see write.go
+	header *headers.Headers
+	wroteHeader bool
+	pendingStatus StatusCode
+	pending []byte // body written before the header section was sent
+	finishChunks bool // Finish ends the chunked body
+}
+
+// Hook lets middleware observe and alter a response while a handler writes
+// it. Each field is optional. When several hooks are added, the last one
+// added runs first: it belongs to the innermost middleware, closest to the
+// handler, so the outer ones see its changes.
+type Hook struct {
+	// StatusLine runs before the status-line is written and returns the
+	// status to send instead, e.g. the code itself to only record it.
+	StatusLine func(statusCode StatusCode) StatusCode
+	// Headers runs before the header section is written and may change it,
+	// including the framing headers.
+	Headers func(h *headers.Headers)
+	// Body runs before each piece of the body is written, by WriteBody or
+	// WriteChunkedBody, and returns what to write instead. A hook that
+	// changes the length of a body sent with Content-Length has to fix that
+	// header in its Headers hook.
+	Body func(p []byte) []byte
 }
 
 func NewWriter(writer io.Writer) *Writer {
@@ -39,37 +72,116 @@ func NewWriter(writer io.Writer) *Writer {
 		writer: writer,
 		writerState: writerStateStatusLine,
 		BodyResponse: []byte(""),
+		httpVersion: "1.1",
+		contentLength: -1,
 	}
 }
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
-	h.Set("Content-Length", strconv.Itoa(contentLen))
-	h.Set("Connection", "close")
-	h.Set("Content-Type", "text/html")
+	h.SetContentLength(int64(contentLen))
+	h.Add("Content-Type", "text/html")
 	return *h
 }
 
+// AddHook registers a hook for the rest of the response.
+func (w *Writer) AddHook(h Hook) {
+	w.hooks = append([]Hook{h}, w.hooks...)
+}
+
+// StatusCode returns the status of the response, or 0 while it has not
+// been picked.
+func (w *Writer) StatusCode() StatusCode {
+	if w.statusCode == 0 {
+		return w.pendingStatus
+	}
+	return w.statusCode
+}
+
+// BytesWritten returns how many bytes of body have been written, after
+// hooks and without chunked framing, counting those Write still buffers.
+func (w *Writer) BytesWritten() int {
+	return w.written + len(w.pending)
+}
+
+// SetKeepAlive tells the writer whether the server intends to reuse the
+// connection after this response. A writer starts out closing it.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection can carry another response after
+// this one: the response must be complete and delimited by its own framing,
+// and neither side may have asked to close.
+func (w *Writer) KeepAlive() bool {
+	switch w.writerState {
+	case writerStateBody:
+		return w.keepAlive && !w.chunked && (w.contentLength < 0 || w.written == w.contentLength)
+	case writerStateDone:
+		return w.keepAlive
+	}
+	return false
+}
+
+// SetHTTPVersion tells the writer which HTTP version the client spoke. An
+// HTTP/1.0 client gets an HTTP/1.0 status-line, no chunked framing (chunks
+// are sent as-is and the response is delimited by closing the connection)
+// and an explicit "Connection: keep-alive" when the connection persists.
+func (w *Writer) SetHTTPVersion(version string) {
+	w.httpVersion = version
+}
+
+// SetRequestMethod tells the writer which method the request used. A
+// response to HEAD keeps the header section a GET would get, Content-Length
+// and Transfer-Encoding included, but ends with it: the body the handler
+// writes is dropped (RFC 9110 9.3.2).
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// WriteStatusLine starts the response with statusCode and its registered
+// reason phrase. An unregistered code is sent without a phrase; use
+// WriteStatusLineReason to give it one. 1xx responses go through
+// WriteInterim instead.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.writeStatusLine(statusCode, "", false)
+}
+
+// WriteStatusLineReason is WriteStatusLine with a custom reason phrase, e.g.
+// for a status code the registry does not know.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if err := validReasonPhrase(reason); err != nil {
+		return err
+	}
+	return w.writeStatusLine(statusCode, reason, true)
+}
+
+func (w *Writer) writeStatusLine(statusCode StatusCode, reason string, customReason bool) error {
 	// RFC 9112 status-line = HTTP-version SP status-code SP [ reason-phrase ]
 
 	if w.writerState != writerStateStatusLine {
 		return fmt.Errorf("cannot write statusline in state %d", w.writerState)
 	}
 
+	for _, h := range w.hooks {
+		if h.StatusLine != nil {
+			statusCode = h.StatusLine(statusCode)
+		}
+	}
+	if err := validStatusCode(statusCode); err != nil {
+		return err
+	}
+	if statusCode.Informational() {
+		return fmt.Errorf("status %d is interim, use WriteInterim", statusCode)
+	}
+	if !customReason {
+		reason = StatusText(statusCode)
+	}
+
 	defer func() {w.writerState = writerStateHeaders}()
 
-	var statusLine []byte 
-	switch statusCode {
-	case StatusOk:
-		statusLine = []byte("HTTP/1.1 200 OK \r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request \r\n")
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error \r\n")
-	default:
-		statusLine = []byte(" \r\n")
-	}
+	w.statusCode = statusCode
+	statusLine := w.statusLine(statusCode, reason)
 	_, err := w.writer.Write(statusLine)
 	if err != nil {
 		return fmt.Errorf("error when writing statusCode: %w", err)
@@ -77,16 +189,122 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+// Started reports whether the status-line has been written, after which
+// the response can no longer be replaced by another one.
+func (w *Writer) Started() bool {
+	return w.writerState != writerStateStatusLine
+}
+
+// WroteHeader reports whether the status has been picked, by WriteHeader or
+// Write, or written. Unlike Started it can be true while nothing has been
+// sent yet; Reset takes the pick back.
+func (w *Writer) WroteHeader() bool {
+	return w.Started() || w.wroteHeader
+}
+
+// WriteInterim sends an informational (1xx) response ahead of the final one,
+// e.g. 100 Continue. It must come before WriteStatusLine, and is skipped for
+// HTTP/1.0 clients, which do not understand 1xx responses (RFC 9110 15.2).
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if w.writerState != writerStateStatusLine {
+		return fmt.Errorf("cannot write interim response in state %d", w.writerState)
+	}
+	if !statusCode.Informational() {
+		return fmt.Errorf("interim response needs a 1xx status, got %d", statusCode)
+	}
+	if w.httpVersion == "1.0" {
+		return nil
+	}
+
+	if err := validateFields(&h); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(w.statusLine(statusCode, StatusText(statusCode))); err != nil {
+		return fmt.Errorf("error when writing statusCode: %w", err)
+	}
+	if err := w.writeFields(&h, nil); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
+		return fmt.Errorf("error when writing header terminator: %w", err)
+	}
+	// the client is waiting for it
+	return w.flushBuffer()
+}
+
+func (w *Writer) statusLine(statusCode StatusCode, reason string) []byte {
+	return []byte(fmt.Sprintf("HTTP/%s %d %s\r\n", w.httpVersion, statusCode, reason))
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.writerState != writerStateHeaders {
 		return fmt.Errorf("cannot write header in state %d", w.writerState)
 	}
 
+	for _, hook := range w.hooks {
+		if hook.Headers != nil {
+			hook.Headers(&h)
+		}
+	}
+	// nothing is written for an invalid header section, and the writer
+	// stays in the headers state
+	if err := validateFields(&h); err != nil {
+		return err
+	}
+
 	defer func() {w.writerState = writerStateBody}()
-	for k, v := range headers.All() {
-		out := fmt.Sprintf("%s: %s\r\n", k, v)
-		_, err := w.writer.Write([]byte(out))
-		if err != nil {
+
+	// a response without Content-Length or chunked framing is delimited by
+	// closing the connection
+	w.chunked = h.HasToken("transfer-encoding", "chunked")
+	if w.chunked && w.httpVersion == "1.0" {
+		w.chunked = false
+		w.unchunked = true
+	}
+	// a 204, a 304 or a response to HEAD ends with its header section,
+	// whatever the headers say
+	w.bodyless = !w.statusCode.bodyAllowed() || w.head
+	if w.bodyless {
+		w.chunked = false
+	}
+	// judged like a request's, so a Content-Length the writer cannot rely
+	// on leaves the body delimited by closing the connection
+	cl, err := h.ContentLength(headers.Strict)
+	validCL := err == nil && cl >= 0 && cl <= math.MaxInt
+	framed := w.chunked || w.bodyless || validCL
+	w.contentLength = -1
+	if validCL && !w.chunked && !w.bodyless {
+		w.contentLength = int(cl)
+	}
+	connection := h.Get("connection")
+	if h.HasToken("connection", "close") || !framed {
+		w.keepAlive = false
+	}
+	if !w.keepAlive {
+		connection = "close"
+	} else if w.httpVersion == "1.0" && connection == "" {
+		connection = "keep-alive"
+	}
+
+	skip := func(name string) bool {
+		switch name {
+		case "connection":
+			return true
+		case "transfer-encoding", "trailer":
+			// a response to HEAD announces the framing a GET would get
+			return w.unchunked || !w.statusCode.bodyAllowed()
+		case "content-length":
+			// a 304 may tell the length of the representation it stands
+			// for, a 204 has none (RFC 9110 8.6)
+			return w.statusCode == StatusNoContent
+		}
+		return false
+	}
+	if err := w.writeFields(&h, skip); err != nil {
+		return err
+	}
+	if connection != "" {
+		if _, err := fmt.Fprintf(w.writer, "Connection: %s\r\n", connection); err != nil {
 			return fmt.Errorf("error when writing header %w", err)
 		}
 	}
@@ -102,9 +320,72 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	n, err := w.writer.Write(p)
+	if w.bodyless {
+		return w.bodyNotAllowed(p)
+	}
+	out := w.runBodyHooks(p)
+	if w.contentLength >= 0 && w.written+len(out) > w.contentLength {
+		return 0, fmt.Errorf("%w: %d", ErrContentLength, w.contentLength)
+	}
+	n, err := w.writer.Write(out)
+	w.written += n
+	if err != nil {
+		return min(n, len(p)), err
+	}
+	return len(p), nil
+}
 
-	return n, err
+// validateFields checks every field line of h before any of it is written,
+// so a value taken from user data cannot inject CRLF and a field of its own.
+func validateFields(h *headers.Headers) error {
+	var err error
+	h.Range(func(name string, value string) bool {
+		switch {
+		case !headers.ValidFieldName(name):
+			err = fmt.Errorf("%w: field name %q", ErrInvalidHeader, name)
+		case !headers.ValidFieldValue(value):
+			err = fmt.Errorf("%w: value of %s: %q", ErrInvalidHeader, name, value)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// writeFields writes the field lines of h in the order they were set and
+// with the casing they were set with, leaving out those skip reports true
+// for; skip gets the lowercased name.
+func (w *Writer) writeFields(h *headers.Headers, skip func(name string) bool) error {
+	var err error
+	h.Range(func(name string, value string) bool {
+		if skip != nil && skip(strings.ToLower(name)) {
+			return true
+		}
+		if _, err = fmt.Fprintf(w.writer, "%s: %s\r\n", name, value); err != nil {
+			err = fmt.Errorf("error when writing header %w", err)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// bodyNotAllowed handles body bytes for a response that cannot have any:
+// those of a response to HEAD are dropped as if sent, since the handler
+// writes the body a GET would get, and any other are rejected.
+func (w *Writer) bodyNotAllowed(p []byte) (int, error) {
+	if w.head || len(p) == 0 {
+		return len(p), nil
+	}
+	return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.statusCode)
+}
+
+// runBodyHooks returns what to write for the body bytes p.
+func (w *Writer) runBodyHooks(p []byte) []byte {
+	for _, h := range w.hooks {
+		if h.Body != nil {
+			p = h.Body(p)
+		}
+	}
+	return p
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
@@ -113,6 +394,19 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
+	if w.bodyless {
+		return w.bodyNotAllowed(p)
+	}
+	p = w.runBodyHooks(p)
+	if w.unchunked {
+		n, err := w.writer.Write(p)
+		w.BodyResponse = append(w.BodyResponse, p[:n]...)
+		w.written += n
+		return n, err
+	}
+	if len(p) == 0 { // a zero-size chunk would end the body
+		return 0, nil
+	}
 	pLen := len(p)
 	outLen := []byte(fmt.Sprintf("%x\r\n",pLen))
 	n, err := w.writer.Write(outLen) 
@@ -132,8 +426,8 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 		return n, err
 	}
 
-	fmt.Println("Done chunk")
 	w.BodyResponse = append(w.BodyResponse, p...)
+	w.written += len(p)
 	return n, err
 }
 
@@ -142,6 +436,9 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
 	defer func(){w.writerState = writerStateTrailers}()
+	if w.unchunked || w.bodyless {
+		return 0, nil
+	}
 	n, err := w.writer.Write([]byte("0\r\n"))
 	return n, err
 }
@@ -150,13 +447,16 @@ func (w *Writer) WriteTrailers(h *headers.Headers) error {
 	if w.writerState != writerStateTrailers {
 		return fmt.Errorf("cannot write trailers in state %d", w.writerState)
 	}
+	defer func(){w.writerState = writerStateDone}()
+	if w.unchunked || w.bodyless { // trailers cannot be sent without chunked framing
+		return nil
+	}
 
-	for k, v := range h.All() {
-		out := fmt.Sprintf("%s: %s\r\n", k, v)
-		_, err := w.writer.Write([]byte(out))
-		if err != nil {
-			return fmt.Errorf("error when writing trailer %w", err)
-		}
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	if err := w.writeFields(h, nil); err != nil {
+		return err
 	}
 	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
 		return fmt.Errorf("error when writing header terminator: %w", err)
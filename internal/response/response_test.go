@@ -0,0 +1,421 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"https/internal/headers"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorPages(t *testing.T) {
+	pages := NewErrorPages()
+	problem := Problem{Status: StatusBadRequest, Detail: "missing <name>", Instance: "/users"}
+	write := func(accept string) string {
+		var out bytes.Buffer
+		require.NoError(t, pages.Write(NewWriter(&out), accept, problem))
+		return out.String()
+	}
+
+	// Test: API clients get problem+json
+	out := write("application/json")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 "))
+	assert.Contains(t, out, "Content-Type: application/problem+json\r\n")
+	assert.Contains(t, out, "Vary: Accept\r\n")
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	assert.Equal(t, map[string]any{
+		"type":     "about:blank",
+		"title":    "Bad Request",
+		"status":   float64(400),
+		"detail":   "missing <name>",
+		"instance": "/users",
+	}, got)
+
+	// Test: Browsers get HTML, with the detail escaped
+	out = write("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	assert.Contains(t, out, "Content-Type: text/html\r\n")
+	assert.Contains(t, out, "<h1>Bad Request</h1>")
+	assert.Contains(t, out, "missing &lt;name&gt;")
+
+	// Test: No preference goes to HTML
+	assert.Contains(t, write(""), "Content-Type: text/html\r\n")
+	assert.Contains(t, write("*/*"), "Content-Type: text/html\r\n")
+
+	// Test: Weights decide
+	assert.Contains(t, write("text/html;q=0.5, application/problem+json"), "application/problem+json")
+	assert.Contains(t, write("application/json;q=0.2, text/*"), "text/html")
+	assert.Contains(t, write("text/html;q=0, */*"), "application/problem+json")
+
+	// Test: Templates per status code
+	pages.SetTemplate(StatusBadRequest, template.Must(template.New("400").Parse("<p>Your request honestly kinda sucked: {{.Detail}}</p>")))
+	assert.True(t, strings.HasSuffix(write("text/html"), "\r\n\r\n<p>Your request honestly kinda sucked: missing &lt;name&gt;</p>"))
+	problem.Status = StatusInternalServerError
+	assert.Contains(t, write("text/html"), "<h1>Internal Server Error</h1>")
+}
+
+func TestStatusLine(t *testing.T) {
+	// Test: Registered codes get their reason phrase and no trailing space
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusTooManyRequests))
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", out.String())
+
+	// Test: Unregistered codes are sent with an empty phrase or a custom one
+	out.Reset()
+	require.NoError(t, NewWriter(&out).WriteStatusLine(599))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", out.String())
+	out.Reset()
+	require.NoError(t, NewWriter(&out).WriteStatusLineReason(599, "Network Read Timeout"))
+	assert.Equal(t, "HTTP/1.1 599 Network Read Timeout\r\n", out.String())
+
+	// Test: Invalid codes and phrases
+	out.Reset()
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLine(99), ErrInvalidStatusCode)
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLine(1000), ErrInvalidStatusCode)
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLineReason(299, "OK\r\nSet-Cookie: x"), ErrInvalidReasonPhrase)
+	assert.Error(t, NewWriter(&out).WriteStatusLine(StatusContinue))
+	assert.Empty(t, out.String())
+}
+
+func TestBodylessStatus(t *testing.T) {
+	// Test: 204 drops the framing headers and refuses a body
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err := w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, err = w.WriteBody(nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "Content-Length")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: 304 keeps Content-Length but never sends chunks
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	h := GetDefaultHeaders(42)
+	h.Add("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(nil))
+	assert.Contains(t, out.String(), "Content-Length: 42\r\n")
+	assert.NotContains(t, out.String(), "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: A response to HEAD gets the Content-Length of the body the
+	// handler wrote, but not the body
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	w.SetRequestMethod("HEAD")
+	n, err := w.Write([]byte("hello body"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Content-Length: 10\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Or Transfer-Encoding, for a body too long to buffer, and no chunks
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	w.SetRequestMethod("HEAD")
+	_, err = w.Write(bytes.Repeat([]byte("x"), 2*bufferedBodyBytes))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.NotContains(t, out.String(), "xxx")
+	assert.True(t, w.KeepAlive())
+
+	// Test: A 204 to HEAD still has no framing headers
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetRequestMethod("HEAD")
+	w.Header().Add("Transfer-Encoding", "chunked")
+	w.WriteHeader(StatusNoContent)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, out.String(), "Transfer-Encoding")
+}
+
+func TestHeaderMapWriter(t *testing.T) {
+	// Test: A small body gets a Content-Length and an implicit 200
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.SetKeepAlive(true)
+	w.Header().Add("Content-Type", "text/plain")
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	assert.Empty(t, out.String(), "nothing is sent before Finish")
+	assert.False(t, w.Started(), "nothing is on the wire yet")
+	assert.True(t, w.WroteHeader())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out.String(), "Content-Length: 11\r\n")
+	assert.Contains(t, out.String(), "Content-Type: text/plain\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nhello world"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: A handler that writes nothing sends an empty 200
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Content-Length: 0\r\n")
+	require.NoError(t, w.Finish(), "Finish is idempotent")
+
+	// Test: A repeated Content-Length is not trusted to frame the body, as
+	// it would not be in a request
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	h := GetDefaultHeaders(2)
+	h.Add("Content-Length", "2")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Connection: close\r\n")
+	assert.False(t, w.KeepAlive())
+
+	// Test: Reset takes back everything not sent yet
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Add("X-Partial", "yes")
+	w.WriteHeader(StatusAccepted)
+	w.Write([]byte("partial"))
+	require.NoError(t, w.Reset())
+	assert.False(t, w.WroteHeader())
+	w.WriteHeader(StatusNotFound)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", out.String())
+	assert.Error(t, w.Reset(), "the response has started")
+
+	// Test: A long body switches to chunked on its own
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	w.WriteHeader(StatusCreated)
+	big := strings.Repeat("x", bufferedBodyBytes+1)
+	n, err := w.Write([]byte(big))
+	require.NoError(t, err)
+	assert.Equal(t, len(big), n)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), big+"\r\n0\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: HTTP/1.0 clients get the long body delimited by the close
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetHTTPVersion("1.0")
+	w.SetKeepAlive(true)
+	w.Write([]byte(big))
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, out.String(), "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"+big))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Writing past a declared Content-Length
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Add("Content-Length", "3")
+	w.Write([]byte("abc"))
+	_, err = w.Write([]byte(big))
+	assert.ErrorIs(t, err, ErrContentLength)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nabc"))
+
+	// Test: Writing less than a declared Content-Length
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetKeepAlive(true)
+	w.Header().Add("Content-Length", "10")
+	w.Write([]byte("abc"))
+	assert.ErrorIs(t, w.Finish(), ErrShortBody)
+	assert.False(t, w.KeepAlive())
+
+	// Test: Only the first WriteHeader counts
+	out.Reset()
+	w = NewWriter(&out)
+	w.WriteHeader(StatusNotFound)
+	w.WriteHeader(StatusOk)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestHeaderOutput(t *testing.T) {
+	// Test: Fields are written in the order they were set, with their
+	// casing, and Connection last
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	h := headers.NewHeaders()
+	h.Add("X-Zebra", "1")
+	h.Add("Content-Length", "0")
+	h.Add("x-apple", "2")
+	h.Add("Connection", "close")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(*h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nX-Zebra: 1\r\nContent-Length: 0\r\nx-apple: 2\r\nConnection: close\r\n\r\n", out.String())
+
+	// Test: Repeated fields go out as separate lines
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	w.Header().Add("Set-Cookie", "b=2")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nSet-Cookie: b=2\r\n")
+
+	// Test: A value smuggling CRLF is rejected before anything is written
+	out.Reset()
+	w = NewWriter(&out)
+	h = headers.NewHeaders()
+	h.Add("Content-Length", "0")
+	h.Add("Location", "/next\r\nSet-Cookie: session=stolen")
+	require.NoError(t, w.WriteStatusLine(StatusFound))
+	out.Reset()
+	assert.ErrorIs(t, w.WriteHeaders(*h), ErrInvalidHeader)
+	assert.Empty(t, out.String())
+
+	// Test: So is a bad field name, in the header map API too
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Add("X-Bad\r\nSet-Cookie", "a")
+	w.Write([]byte("body"))
+	assert.ErrorIs(t, w.Finish(), ErrInvalidHeader)
+	assert.NotContains(t, out.String(), "Set-Cookie")
+
+	// Test: Trailers are validated as well
+	out.Reset()
+	w = NewWriter(&out)
+	h = headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	out.Reset()
+	trailers := headers.NewHeaders()
+	trailers.Add("X-Checksum", "abc\n")
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrInvalidHeader)
+	assert.Empty(t, out.String())
+}
+
+func TestBufferedWriter(t *testing.T) {
+	// Test: Output is held back until Flush
+	var out bytes.Buffer
+	w := NewBufferedWriter(&out)
+	w.SetKeepAlive(true)
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Add("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	var flusher Flusher = w
+	require.NoError(t, flusher.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n5\r\nhello\r\n"))
+
+	// Test: Finish flushes, and the writer is unusable after Release
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "0\r\n\r\n"))
+	require.NoError(t, w.Release())
+	_, err = w.writer.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrWriterReleased)
+
+	// Test: Interim responses are not held back
+	out.Reset()
+	w = NewBufferedWriter(&out)
+	require.NoError(t, w.WriteInterim(StatusContinue, *headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", out.String())
+	w.Release()
+
+	// Test: Flush commits the header section of the Header and Write API
+	out.Reset()
+	w = NewBufferedWriter(&out)
+	w.Write([]byte("partial"))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "7\r\npartial\r\n"))
+	w.Release()
+}
+
+// countingWriter counts the Write calls that reach the connection, each of
+// which would be a syscall and likely a TCP segment of its own.
+type countingWriter struct {
+	dst    io.Writer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.dst.Write(p)
+}
+
+// writeChunkedResponse writes a response the way the httpbin proxy does.
+func writeChunkedResponse(w *Writer) {
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Content-Length")
+	w.WriteStatusLine(StatusOk)
+	w.WriteHeaders(h)
+	chunk := bytes.Repeat([]byte("x"), 256)
+	for i := 0; i < 16; i++ {
+		w.WriteChunkedBody(chunk)
+	}
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	trailers.Add("X-Content-Length", "4096")
+	w.WriteTrailers(trailers)
+}
+
+func BenchmarkWriter(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(b, err)
+	defer devNull.Close()
+
+	b.Run("unbuffered", func(b *testing.B) {
+		conn := &countingWriter{dst: devNull}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := NewWriter(conn)
+			writeChunkedResponse(w)
+			w.Finish()
+		}
+		b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+	})
+	b.Run("buffered", func(b *testing.B) {
+		conn := &countingWriter{dst: devNull}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := NewBufferedWriter(conn)
+			writeChunkedResponse(w)
+			w.Finish()
+			w.Release()
+		}
+		b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+	})
+}
@@ -0,0 +1,164 @@
+package response
+
+import (
+	"fmt"
+	"https/internal/headers"
+)
+
+/*
+The Header, WriteHeader and Write methods are an alternative to writing the
+status-line, headers and body step by step: the handler fills in a header
+map, optionally picks a status, and writes the body, and the writer takes
+care of the framing. Finish, which the server calls once the handler
+returns, sends whatever is still buffered.
+
+A body that fits in bufferedBodyBytes by the time the handler returns is
+sent with a Content-Length computed by the writer. A longer one is sent
+chunked (or, to an HTTP/1.0 client, delimited by closing the connection),
+unless the handler declared a Content-Length or Transfer-Encoding itself.
+*/
+
+// bufferedBodyBytes is how much of a body Write holds back to find out
+// whether the whole body will fit under a Content-Length.
+const bufferedBodyBytes = 4096
+
+var ErrContentLength = fmt.Errorf("body longer than the declared Content-Length")
+var ErrShortBody = fmt.Errorf("body shorter than the declared Content-Length")
+
+// Header returns the header map that WriteHeader or the first Write sends.
+// Changing it afterwards has no effect.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// WriteHeader picks the status of the response; the header section itself
+// goes out with the body. Only the first call counts, and a handler that
+// never calls it gets a 200. A 1xx status is sent right away as an interim
+// response with the current header map instead.
+func (w *Writer) WriteHeader(statusCode StatusCode) {
+	if statusCode.Informational() {
+		w.WriteInterim(statusCode, *w.Header())
+		return
+	}
+	if w.WroteHeader() {
+		return
+	}
+	w.wroteHeader = true
+	w.pendingStatus = statusCode
+}
+
+// Write writes p as part of the body, picking a 200 status if WriteHeader
+// has not been called. It is also how a handler that wrote the header
+// section with WriteHeaders can write a body without picking WriteBody or
+// WriteChunkedBody itself.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.writerState == writerStateStatusLine {
+		w.WriteHeader(StatusOk)
+		if len(w.pending)+len(p) <= bufferedBodyBytes {
+			w.pending = append(w.pending, p...)
+			return len(p), nil
+		}
+		if err := w.commit(false); err != nil {
+			return 0, err
+		}
+	}
+	return w.write(p)
+}
+
+// Reset throws away the status, header map and buffered body the handler
+// has picked so far, so that a different response can be sent instead, e.g.
+// an error page. It fails once the response has started.
+func (w *Writer) Reset() error {
+	if w.Started() {
+		return fmt.Errorf("cannot reset response in state %d", w.writerState)
+	}
+	w.wroteHeader = false
+	w.pendingStatus = 0
+	w.pending = nil
+	w.header = nil
+	return nil
+}
+
+// write sends p with the framing the header section picked.
+func (w *Writer) write(p []byte) (int, error) {
+	if !w.chunked {
+		return w.WriteBody(p)
+	}
+	if _, err := w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Finish completes the response after the handler returns: it sends the
+// header section and body that are still buffered, ends a chunked body the
+// writer chose and flushes the output. ErrShortBody means the handler wrote
+// less than the Content-Length it declared; the connection must not be
+// reused. Finish is a no-op for a response that is already complete.
+func (w *Writer) Finish() error {
+	err := w.finish()
+	if flushErr := w.flushBuffer(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if w.writerState == writerStateStatusLine {
+		w.WriteHeader(StatusOk)
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	if w.writerState != writerStateBody {
+		return nil
+	}
+
+	switch {
+	case w.chunked || (w.unchunked && w.finishChunks):
+		if !w.finishChunks {
+			return nil // the handler owns the end of its chunked body
+		}
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	case w.contentLength >= 0 && w.written < w.contentLength:
+		w.keepAlive = false
+		return fmt.Errorf("%w: wrote %d of %d bytes", ErrShortBody, w.written, w.contentLength)
+	}
+	w.writerState = writerStateDone
+	return nil
+}
+
+// commit sends the status-line and header section, then the buffered body.
+// With final set the buffered body is all there is, so it gets a
+// Content-Length.
+func (w *Writer) commit(final bool) error {
+	h := w.Header()
+	if h.Get("content-length") == "" && h.Get("transfer-encoding") == "" {
+		if final {
+			h.SetContentLength(int64(len(w.pending)))
+		} else {
+			h.Replace("Transfer-Encoding", "chunked")
+		}
+	}
+	if err := w.WriteStatusLine(w.pendingStatus); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	w.finishChunks = w.chunked || w.unchunked
+
+	pending := w.pending
+	w.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	_, err := w.write(pending)
+	return err
+}
@@ -0,0 +1,138 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// segmentKind orders segments by how specific they are: when several
+// patterns match a path, the one with a literal where another has a
+// parameter wins, and a parameter beats a wildcard.
+type segmentKind int
+
+const (
+	literalSegment  segmentKind = iota // /users
+	paramSegment                       // /{id}
+	wildcardSegment                    // /{path...}, last segment only
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // the text of a literal, the name of a parameter
+}
+
+// pattern is a parsed route pattern such as "/users/{id}/files/{path...}".
+type pattern struct {
+	raw      string
+	segments []segment
+}
+
+var ErrBadPattern = fmt.Errorf("bad route pattern")
+
+func parsePattern(raw string) (*pattern, error) {
+	if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("%w %q: must start with /", ErrBadPattern, raw)
+	}
+	p := &pattern{raw: raw}
+	names := map[string]bool{}
+	parts := strings.Split(raw[1:], "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("%w %q: braces must enclose a whole segment", ErrBadPattern, raw)
+			}
+			p.segments = append(p.segments, segment{kind: literalSegment, value: part})
+			continue
+		}
+		if !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("%w %q: braces must enclose a whole segment", ErrBadPattern, raw)
+		}
+		name := part[1 : len(part)-1]
+		kind := paramSegment
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("%w %q: %s must be the last segment", ErrBadPattern, raw, part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = wildcardSegment
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("%w %q: bad parameter name in %s", ErrBadPattern, raw, part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%w %q: duplicate parameter %s", ErrBadPattern, raw, name)
+		}
+		names[name] = true
+		p.segments = append(p.segments, segment{kind: kind, value: name})
+	}
+	return p, nil
+}
+
+// match matches the pattern against the still percent-encoded segments of
+// a path and returns the decoded parameter values. With prefix set the
+// pattern only has to match the leading segments, and rest is how many are
+// left over.
+func (p *pattern) match(segs []string, prefix bool) (values map[string]string, rest int, ok bool) {
+	values = map[string]string{}
+	for i, seg := range p.segments {
+		if i >= len(segs) {
+			return nil, 0, false
+		}
+		switch seg.kind {
+		case literalSegment:
+			decoded, err := url.PathUnescape(segs[i])
+			if err != nil || decoded != seg.value {
+				return nil, 0, false
+			}
+		case paramSegment:
+			decoded, err := url.PathUnescape(segs[i])
+			if err != nil {
+				return nil, 0, false
+			}
+			values[seg.value] = decoded
+		case wildcardSegment:
+			decoded, err := url.PathUnescape(strings.Join(segs[i:], "/"))
+			if err != nil {
+				return nil, 0, false
+			}
+			values[seg.value] = decoded
+			return values, 0, true
+		}
+	}
+	rest = len(segs) - len(p.segments)
+	if rest > 0 && !prefix {
+		return nil, 0, false
+	}
+	return values, rest, true
+}
+
+// moreSpecific reports whether p should win over q when both match a path.
+func (p *pattern) moreSpecific(q *pattern) bool {
+	for i := 0; i < len(p.segments) && i < len(q.segments); i++ {
+		if p.segments[i].kind != q.segments[i].kind {
+			return p.segments[i].kind < q.segments[i].kind
+		}
+	}
+	return len(p.segments) > len(q.segments)
+}
+
+// conflicts reports whether p and q match exactly the same paths.
+func (p *pattern) conflicts(q *pattern) bool {
+	if len(p.segments) != len(q.segments) {
+		return false
+	}
+	for i, seg := range p.segments {
+		other := q.segments[i]
+		if seg.kind != other.kind || (seg.kind == literalSegment && seg.value != other.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath splits an absolute path into its segments: "/a/b" into
+// ["a", "b"], "/" into [""].
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
@@ -0,0 +1,268 @@
+/*
+Package router dispatches requests to handlers by method and path.
+
+A pattern is an absolute path whose segments are either literal text, a
+parameter such as "{id}" that matches exactly one segment, or, as the last
+segment only, a wildcard such as "{path...}" that matches the rest of the
+path. Parameter values are percent-decoded and can be read from the request
+with Request.PathValue:
+
+	rt := router.New()
+	rt.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		id := req.PathValue("id")
+		...
+	})
+	server.Serve(42069, rt.ServeRequest)
+
+When several patterns match a path the most specific one wins, comparing
+segment by segment: a literal beats a parameter, which beats a wildcard.
+Requests for a path that no pattern matches get a 404, and requests for a
+path that only matches routes of other methods get a 405 with an Allow
+header. OPTIONS is answered automatically for any path that has routes,
+and a GET route also answers HEAD unless a HEAD route for the same path is
+registered; the server drops the body of a response to HEAD.
+*/
+package router
+
+import (
+	"fmt"
+	"https/internal/request"
+	"https/internal/response"
+	"https/internal/server"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type route struct {
+	method  string
+	pattern *pattern
+	handler server.Handler
+}
+
+// serves reports whether r handles requests with method: its own, and HEAD
+// for a GET route (RFC 9110 9.3.2).
+func (r *route) serves(method string) bool {
+	return r.method == method || (method == "HEAD" && r.method == "GET")
+}
+
+type mount struct {
+	prefix  *pattern // nil for "/", which matches every path
+	handler server.Handler
+}
+
+// Router is a server.Handler, through its ServeRequest method, that
+// dispatches to the handler registered for the method and path of the
+// request. Register every route before serving; the Router is not safe for
+// concurrent registration.
+type Router struct {
+	routes []*route
+	mounts []*mount
+	// NotFound answers requests whose path matches no route and no mount. If
+	// nil, a plain-text 404 is sent.
+	NotFound server.Handler
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for requests with the given method whose path
+// matches pattern. It panics if pattern is malformed or the same method
+// and pattern were already registered, since both are programming errors.
+func (rt *Router) Handle(method string, pattern string, handler server.Handler) {
+	p, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	if method == "" {
+		panic(fmt.Sprintf("router: no method for pattern %q", pattern))
+	}
+	for _, r := range rt.routes {
+		if r.method == method && r.pattern.conflicts(p) {
+			panic(fmt.Sprintf("router: %s %q conflicts with %s %q", method, pattern, r.method, r.pattern.raw))
+		}
+	}
+	rt.routes = append(rt.routes, &route{method: method, pattern: p, handler: handler})
+}
+
+func (rt *Router) Get(pattern string, handler server.Handler) {
+	rt.Handle("GET", pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler server.Handler) {
+	rt.Handle("POST", pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler server.Handler) {
+	rt.Handle("PUT", pattern, handler)
+}
+
+func (rt *Router) Patch(pattern string, handler server.Handler) {
+	rt.Handle("PATCH", pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler server.Handler) {
+	rt.Handle("DELETE", pattern, handler)
+}
+
+// Mount hands every request under prefix that no route of rt matches to
+// handler, typically the ServeRequest of another Router. The handler sees
+// the path with prefix stripped, so a sub-router mounted at "/api" matches
+// "/api/users" against "/users". Parameters in prefix are matched like in
+// a route and stay readable with PathValue.
+func (rt *Router) Mount(prefix string, handler server.Handler) {
+	m := &mount{handler: handler}
+	if trimmed := strings.TrimSuffix(prefix, "/"); trimmed != "" {
+		p, err := parsePattern(trimmed)
+		if err != nil {
+			panic(err)
+		}
+		for _, seg := range p.segments {
+			if seg.kind == wildcardSegment {
+				panic(fmt.Sprintf("router: mount prefix %q cannot have a wildcard", prefix))
+			}
+		}
+		m.prefix = p
+	}
+	rt.mounts = append(rt.mounts, m)
+}
+
+// ServeRequest dispatches req. Its method value is a server.Handler.
+func (rt *Router) ServeRequest(w *response.Writer, req *request.Request) {
+	if req.Target.Form == request.AsteriskForm { // "OPTIONS *" asks about the server as a whole
+		writeAllow(w, rt.methods(nil))
+		return
+	}
+	if req.Target.Path == "" { // authority-form, CONNECT
+		rt.notFound(w, req)
+		return
+	}
+
+	segs := splitPath(req.Target.RawPath)
+	var best *route
+	var bestValues map[string]string
+	var matched []*route
+	for _, r := range rt.routes {
+		values, _, ok := r.pattern.match(segs, false)
+		if !ok {
+			continue
+		}
+		matched = append(matched, r)
+		if !r.serves(req.RequestLine.Method) {
+			continue
+		}
+		// between equally specific routes, one for the method itself beats
+		// a GET route answering HEAD
+		exact := r.method == req.RequestLine.Method
+		if best == nil || r.pattern.moreSpecific(best.pattern) || (exact && !best.pattern.moreSpecific(r.pattern)) {
+			best, bestValues = r, values
+		}
+	}
+
+	switch {
+	case best != nil:
+		for name, value := range bestValues {
+			req.SetPathValue(name, value)
+		}
+		best.handler(w, req)
+	case len(matched) == 0:
+		if !rt.serveMount(w, req, segs) {
+			rt.notFound(w, req)
+		}
+	case req.RequestLine.Method == "OPTIONS":
+		writeAllow(w, rt.methods(matched))
+	default:
+		writeMethodNotAllowed(w, rt.methods(matched))
+	}
+}
+
+// serveMount passes req on to the mount with the longest prefix matching
+// segs, and reports whether there was one.
+func (rt *Router) serveMount(w *response.Writer, req *request.Request, segs []string) bool {
+	var best *mount
+	var bestValues map[string]string
+	bestRest := len(segs) + 1
+	for _, m := range rt.mounts {
+		if m.prefix == nil {
+			if best == nil {
+				best, bestValues, bestRest = m, nil, len(segs)
+			}
+			continue
+		}
+		values, rest, ok := m.prefix.match(segs, true)
+		if ok && rest < bestRest {
+			best, bestValues, bestRest = m, values, rest
+		}
+	}
+	if best == nil {
+		return false
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, stripPrefix(req, segs[len(segs)-bestRest:]))
+	return true
+}
+
+// stripPrefix returns a shallow copy of req whose target path is only the
+// remaining segments rest.
+func stripPrefix(req *request.Request, rest []string) *request.Request {
+	target := *req.Target
+	target.RawPath = "/" + strings.Join(rest, "/")
+	// RawPath was already validated when the request was parsed
+	target.Path, _ = url.PathUnescape(target.RawPath)
+	stripped := *req
+	stripped.Target = &target
+	return &stripped
+}
+
+func (rt *Router) notFound(w *response.Writer, req *request.Request) {
+	if rt.NotFound != nil {
+		rt.NotFound(w, req)
+		return
+	}
+	writePlain(w, response.StatusNotFound, "404 not found\n", "")
+}
+
+// methods returns the methods of routes for the Allow header, or of every
+// route if routes is nil, sorted and with OPTIONS included, and HEAD too
+// when there is a GET route.
+func (rt *Router) methods(routes []*route) []string {
+	if routes == nil {
+		routes = rt.routes
+	}
+	seen := map[string]bool{"OPTIONS": true}
+	for _, r := range routes {
+		seen[r.method] = true
+		if r.method == "GET" {
+			seen["HEAD"] = true
+		}
+	}
+	methods := make([]string, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// writeAllow answers an OPTIONS request. RFC 9110 9.3.7 asks for an explicit
+// "Content-Length: 0" when there is no content.
+func writeAllow(w *response.Writer, methods []string) {
+	writePlain(w, response.StatusOk, "", strings.Join(methods, ", "))
+}
+
+func writeMethodNotAllowed(w *response.Writer, methods []string) {
+	writePlain(w, response.StatusMethodNotAllowed, "405 method not allowed\n", strings.Join(methods, ", "))
+}
+
+func writePlain(w *response.Writer, statusCode response.StatusCode, msg string, allow string) {
+	w.Header().Replace("Content-Type", "text/plain")
+	if allow != "" {
+		w.Header().Replace("Allow", allow)
+	}
+	w.WriteHeader(statusCode)
+	w.Write([]byte(msg))
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"bytes"
+	"https/internal/request"
+	"https/internal/response"
+	"https/internal/server"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs rt on a raw request and returns the raw response.
+func serve(t *testing.T, rt *Router, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	w.SetRequestMethod(req.RequestLine.Method)
+	rt.ServeRequest(w, req)
+	require.NoError(t, w.Finish())
+	return out.String()
+}
+
+// reply is a handler that answers with its name and the given path values.
+func reply(name string, params ...string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Get("/", reply("root"))
+	rt.Get("/users/{id}", reply("user", "id"))
+	rt.Get("/users/me", reply("me"))
+	rt.Put("/users/{id}", reply("put", "id"))
+	rt.Get("/files/{path...}", reply("file", "path"))
+
+	// Test: Literal routes, parameters and wildcards
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"), "\r\n\r\nroot"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /users/42 HTTP/1.1\r\nHost: x\r\n\r\n"), "user id=42"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "PUT /users/42 HTTP/1.1\r\nHost: x\r\n\r\n"), "put id=42"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /files/a/b%2Fc.txt HTTP/1.1\r\nHost: x\r\n\r\n"), "file path=a/b/c.txt"))
+
+	// Test: Parameters are decoded, and an encoded slash stays in its segment
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /users/a%2Fb HTTP/1.1\r\nHost: x\r\n\r\n"), "user id=a/b"))
+
+	// Test: A literal segment beats a parameter
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /users/me HTTP/1.1\r\nHost: x\r\n\r\n"), "me"))
+
+	// Test: Unknown path
+	out := serve(t, rt, "GET /nope HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 "))
+	out = serve(t, rt, "GET /users/42/extra HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 "))
+
+	// Test: Known path, wrong method
+	out = serve(t, rt, "DELETE /users/42 HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 "))
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, PUT\r\n")
+
+	// Test: Automatic OPTIONS
+	out = serve(t, rt, "OPTIONS /users/me HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 "))
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, PUT\r\n")
+	assert.Contains(t, out, "Content-Length: 0\r\n")
+	out = serve(t, rt, "OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, PUT\r\n")
+
+	// Test: A GET route answers HEAD, without the body
+	out = serve(t, rt, "HEAD /users/42 HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 "))
+	assert.Contains(t, out, "Content-Length: 10\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: Unless a HEAD route is as specific
+	rt.Handle("HEAD", "/users/{id}", reply("explicit head", "id"))
+	out = serve(t, rt, "HEAD /users/42 HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "Content-Length: 19\r\n")
+	out = serve(t, rt, "HEAD /users/me HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "Content-Length: 2\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: Custom NotFound
+	rt.NotFound = reply("fallback")
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /nope HTTP/1.1\r\nHost: x\r\n\r\n"), "fallback"))
+}
+
+func TestMount(t *testing.T) {
+	api := New()
+	api.Get("/", reply("api root"))
+	api.Get("/users/{id}", reply("api user", "org", "id"))
+
+	rt := New()
+	rt.Get("/orgs/{org}/about", reply("about", "org"))
+	rt.Mount("/orgs/{org}/api", api.ServeRequest)
+	rt.Mount("/", reply("catch-all"))
+
+	// Test: The sub-router sees the path without the prefix
+	out := serve(t, rt, "GET /orgs/acme/api/users/7 HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "api user org=acme id=7"))
+	out = serve(t, rt, "GET /orgs/acme/api HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "api root"))
+
+	// Test: Routes of the parent win over its mounts
+	out = serve(t, rt, "GET /orgs/acme/about HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "about org=acme"))
+
+	// Test: The sub-router answers 404 and 405 for its own paths
+	out = serve(t, rt, "GET /orgs/acme/api/nope HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 "))
+	out = serve(t, rt, "POST /orgs/acme/api/users/7 HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 "))
+
+	// Test: Mounting at the root catches everything else
+	out = serve(t, rt, "GET /elsewhere HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "catch-all"))
+}
+
+func TestPattern(t *testing.T) {
+	for _, bad := range []string{"users", "/{}", "/{a}/{a}", "/{rest...}/x", "/a{b}", "/{a"} {
+		_, err := parsePattern(bad)
+		assert.ErrorIs(t, err, ErrBadPattern, bad)
+	}
+
+	rt := New()
+	rt.Get("/users/{id}", reply("user"))
+	assert.Panics(t, func() { rt.Get("/users/{name}", reply("user")) })
+	assert.NotPanics(t, func() { rt.Post("/users/{name}", reply("user")) })
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"https/internal/request"
+	"os"
+	"time"
+)
+
+// Config holds the knobs of a Server. A zero duration disables that
+// timeout, and a zero MaxRequestsPerConn means no limit. Start from
+// DefaultConfig rather than a zero Config, which enforces no limits at all.
+type Config struct {
+	// Network is "tcp", "tcp4", "tcp6" or "unix". Empty means "tcp".
+	Network string
+	// Addr is what to listen on: "host:port" for the TCP networks (an empty
+	// host listens on every interface, e.g. ":42069" or "[::1]:8080") or
+	// a file path for "unix".
+	Addr string
+	// UnixSocketMode, if not zero, is applied to the socket file of a
+	// "unix" listener, e.g. 0660 to let a sidecar in the same group connect.
+	UnixSocketMode os.FileMode
+
+	// ReadHeaderTimeout bounds reading the request-line and headers, from
+	// the first byte of the request. A client that runs out of time gets a
+	// 408. Zero falls back to ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request, body included.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, from the end of the header
+	// read until the handler returns.
+	WriteTimeout time.Duration
+	// HandlerTimeout is how long a handler may work on one request before
+	// its context is cancelled.
+	HandlerTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may sit between
+	// requests before it is closed. Zero falls back to ReadTimeout.
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn caps how many requests one connection serves
+	// before the server asks the client to reconnect.
+	MaxRequestsPerConn int
+
+	// RequestOptions bounds the size of each request: its request-line,
+	// header and trailer sections and body. A zero limit is not enforced,
+	// as in request.Options.
+	RequestOptions request.Options
+}
+
+const (
+	DefaultNetwork            = "tcp"
+	DefaultReadHeaderTimeout  = 10 * time.Second
+	DefaultReadTimeout        = 2 * time.Minute
+	DefaultWriteTimeout       = 2 * time.Minute
+	DefaultHandlerTimeout     = 2 * time.Minute
+	DefaultIdleTimeout        = 60 * time.Second
+	DefaultMaxRequestsPerConn = 100
+)
+
+func DefaultConfig() Config {
+	return Config{
+		Network:            DefaultNetwork,
+		ReadHeaderTimeout:  DefaultReadHeaderTimeout,
+		ReadTimeout:        DefaultReadTimeout,
+		WriteTimeout:       DefaultWriteTimeout,
+		HandlerTimeout:     DefaultHandlerTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		MaxRequestsPerConn: DefaultMaxRequestsPerConn,
+		RequestOptions:     request.DefaultOptions(),
+	}
+}
+
+func (c Config) readHeaderTimeout() time.Duration {
+	if c.ReadHeaderTimeout > 0 {
+		return c.ReadHeaderTimeout
+	}
+	return c.ReadTimeout
+}
+
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return c.ReadTimeout
+}
+
+// deadline turns a timeout into a connection deadline; zero means none.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
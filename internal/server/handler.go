@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"https/internal/request"
+	"https/internal/response"
+	"log"
+)
+
+// ErrorHandler is a Handler that can fail. Instead of writing an error
+// response itself it returns an error, which HandleErrors turns into one: a
+// *HandlerError picks the status and message, any other error becomes a 500
+// whose details are only logged.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// ErrorRenderer writes the response for an error an ErrorHandler returned
+// before starting its own response. message is meant for the client.
+type ErrorRenderer func(w *response.Writer, req *request.Request, statusCode response.StatusCode, message string)
+
+// ErrAbortHandler, when panicked by a handler, closes the connection at
+// once without logging a stack trace, e.g. because a response that has
+// already started cannot be completed.
+var ErrAbortHandler = fmt.Errorf("abort handler")
+
+// HandleErrors adapts h to a Handler, rendering its errors with
+// DefaultErrorRenderer.
+func HandleErrors(h ErrorHandler) Handler {
+	return HandleErrorsWithRenderer(h, DefaultErrorRenderer)
+}
+
+// HandleErrorsWithRenderer adapts h to a Handler, rendering its errors with
+// render.
+//
+// An error replaces whatever response h picked or buffered but has not sent
+// yet. An error returned after h started its response cannot be reported to
+// the client any more, and the response is probably incomplete, so it is
+// logged and the connection is closed rather than left to look like a valid
+// response.
+func HandleErrorsWithRenderer(h ErrorHandler, render ErrorRenderer) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+
+		statusCode := response.StatusInternalServerError
+		message := "internal server error"
+		var handlerErr *HandlerError
+		if errors.As(err, &handlerErr) {
+			statusCode, message = handlerErr.StatusCode, handlerErr.Message
+		}
+		if w.Reset() != nil {
+			log.Printf("error after the response to %s %s started, aborting: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			panic(ErrAbortHandler)
+		}
+		if statusCode >= 500 {
+			log.Printf("error serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+		render(w, req, statusCode, message)
+	}
+}
+
+// DefaultErrorRenderer sends the status and message as plain text.
+func DefaultErrorRenderer(w *response.Writer, req *request.Request, statusCode response.StatusCode, message string) {
+	writeError(w, statusCode, fmt.Sprintf("%d %s\n", statusCode, message))
+}
+
+// ErrorPagesRenderer renders errors with pages, as problem+json or HTML
+// depending on the Accept header of the request.
+func ErrorPagesRenderer(pages *response.ErrorPages) ErrorRenderer {
+	return func(w *response.Writer, req *request.Request, statusCode response.StatusCode, message string) {
+		problem := response.Problem{
+			Status:   statusCode,
+			Detail:   message,
+			Instance: req.Target.RawPath,
+		}
+		if err := pages.Write(w, req.Headers.Get("accept"), problem); err != nil {
+			log.Printf("error writing error response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+// Middleware wraps a Handler with behavior shared by many handlers, such as
+// logging or authentication. It can act before and after calling next, skip
+// it entirely, or watch and change the response through
+// response.Writer.AddHook.
+type Middleware func(next Handler) Handler
+
+// Chain is an ordered list of middleware. The first one is the outermost:
+// it sees the request first and the response last.
+type Chain []Middleware
+
+func NewChain(middlewares ...Middleware) Chain {
+	return append(Chain(nil), middlewares...)
+}
+
+// Append returns a new chain with middlewares added after those of c, so
+// chains can share a common base without affecting each other.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	out := make(Chain, 0, len(c)+len(middlewares))
+	out = append(out, c...)
+	return append(out, middlewares...)
+}
+
+// Then wraps handler in every middleware of the chain.
+func (c Chain) Then(handler Handler) Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+	return handler
+}
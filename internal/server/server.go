@@ -1,20 +1,35 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"https/internal/headers"
 	"https/internal/request"
 	"https/internal/response"
+	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// HandlerError is an error an ErrorHandler returns to choose the status of
+// the error response and the message shown to the client.
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message string
 }
 
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
 // The handler writes a success response body to w if everything goes well and returns nil
 type Handler func(w *response.Writer, req *request.Request)
 
@@ -22,30 +37,293 @@ type Server struct {
 	listener net.Listener
 	close atomic.Bool
 	handler Handler
+	config Config
+
+	mu sync.Mutex
+	conns map[net.Conn]connState
+
+	// ctx is the parent of every request context; cancelled once the
+	// server gives up on its connections
+	ctx context.Context
+	cancel context.CancelFunc
 }
 
+// connState is where a connection is in its request/response cycle, so
+// Shutdown knows which connections it can close without cutting off a
+// response.
+type connState int
+
+const (
+	connIdle connState = iota // waiting for the first byte of a request
+	connActive                // reading a request or writing its response
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the active
+// connections have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Close stops accepting connections and closes every open connection
+// immediately, including ones in the middle of a response. Use Shutdown to
+// let those finish.
 func (s *Server) Close() error {
-	err := s.listener.Close()
 	s.close.Store(true)
+	err := s.listener.Close()
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
 	return err
 }
 
 /*
-Handle single conection then close
+Shutdown stops accepting connections, closes the idle keep-alive ones and
+waits for the active ones to finish their current response; they are closed
+after it instead of waiting for another request. If ctx expires first the
+remaining connections are closed anyway and their request contexts
+cancelled, and dropped reports how many responses were cut off that way.
+*/
+func (s *Server) Shutdown(ctx context.Context) (dropped int, err error) {
+	s.close.Store(true)
+	err = s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdle() {
+			s.cancel()
+			return 0, err
+		}
+		select {
+		case <-ctx.Done():
+			s.cancel()
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for conn := range s.conns {
+				conn.Close()
+				dropped++
+			}
+			return dropped, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdle closes the idle connections and reports whether every
+// connection is gone.
+func (s *Server) closeIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connIdle {
+			conn.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// trackConn records the state of conn, or forgets it once it is closed.
+func (s *Server) trackConn(conn net.Conn, state connState, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closed {
+		delete(s.conns, conn)
+		return
+	}
+	s.conns[conn] = state
+}
+
+/*
+Handle the requests on a connection one at a time, in the order they arrive,
+until either side asks to close, the connection goes idle for too long or it
+reaches its request limit. Handling pipelined requests sequentially keeps the
+responses in request order.
 */
 func (s *Server) handleConnection(conn net.Conn, handler Handler) {
 	defer conn.Close() // DOC: why we defer instead of putting it in the end
+	defer s.trackConn(conn, connIdle, true)
 	
 	fmt.Println("Handling the new connection")
-	
-	responseWriter := response.NewWriter(conn)
-	r, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(response.GetDefaultHeaders(0))
+
+	rr := request.NewRequestReader(conn, s.config.RequestOptions)
+	for served := 1; ; served++ {
+		// the connection only counts as active once a request starts
+		// arriving, so Shutdown does not wait on clients that send nothing
+		s.trackConn(conn, connIdle, false)
+		if s.close.Load() {
+			return
+		}
+		wait := s.config.idleTimeout()
+		if served == 1 {
+			wait = s.config.readHeaderTimeout()
+		}
+		conn.SetReadDeadline(deadline(time.Now(), wait))
+		if err := rr.WaitForRequest(); err != nil {
+			return // client went away, idled out or the server shut down
+		}
+		s.trackConn(conn, connActive, false)
+
+		start := time.Now()
+		conn.SetReadDeadline(deadline(start, s.config.readHeaderTimeout()))
+		r, err := rr.ReadRequest()
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				conn.SetWriteDeadline(time.Now().Add(time.Second))
+				writeError(response.NewWriter(conn), response.StatusRequestTimeout, "408 request timeout\n")
+			case errors.Is(err, io.EOF), errors.As(err, &netErr):
+				// client went away
+			default:
+				writeParseError(conn, err)
+			}
+			return
+		}
+		conn.SetReadDeadline(deadline(start, s.config.ReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
+
+		responseWriter := response.NewBufferedWriter(conn)
+		responseWriter.SetHTTPVersion(r.RequestLine.HTTPVersion)
+		responseWriter.SetRequestMethod(r.RequestLine.Method)
+		underLimit := s.config.MaxRequestsPerConn <= 0 || served < s.config.MaxRequestsPerConn
+		responseWriter.SetKeepAlive(wantsKeepAlive(r) && underLimit && !s.close.Load())
+
+		if expect := r.Headers.Get("expect"); expect != "" && r.RequestLine.HTTPVersion != "1.0" {
+			if !r.ExpectsContinue() {
+				writeError(responseWriter, response.StatusExpectationFailed, "417 expectation failed: "+expect+"\n")
+				return
+			}
+			// only ask for the body once the handler reads it, so it can still
+			// reject the request (413, 401, ...) before any of it is sent
+			continued := false
+			r.SetContinueFunc(func() error {
+				continued = true
+				// the response is already under way, so the client can stop
+				// waiting once the buffered part of it arrives
+				if responseWriter.Started() {
+					return responseWriter.Flush()
+				}
+				return responseWriter.WriteInterim(response.StatusContinue, *headers.NewHeaders())
+			})
+			// a client that was never asked for the body may or may not send
+			// it anyway, so there is no telling where the next request starts
+			if r.ContentLength != 0 {
+				responseWriter.AddHook(response.Hook{Headers: func(*headers.Headers) {
+					if !continued {
+						responseWriter.SetKeepAlive(false)
+					}
+				}})
+			}
+		}
+
+		ctx, cancel := s.requestContext()
+		rr.WatchClose(cancel)
+		aborted := s.runHandler(responseWriter, r.WithContext(ctx))
+		rr.StopWatching(func() { conn.SetReadDeadline(aLongTimeAgo) })
+		cancel()
+		if aborted {
+			return
+		}
+		err = responseWriter.Finish()
+		responseWriter.Release()
+		if err != nil {
+			log.Printf("error finishing response: %v", err)
+			return
+		}
+
+		// drain whatever the handler left unread; closing a socket with unread
+		// data makes the kernel send RST, which can cut off our response
+		if err := r.Body.Close(); err != nil {
+			log.Printf("error draining request body: %v", err)
+			return
+		}
+		if !responseWriter.KeepAlive() || s.close.Load() {
+			return
+		}
+	}
+}
+
+/*
+runHandler calls the handler and recovers if it panics, so one bad request
+cannot take the whole process down. If the response has not started the
+client gets a 500 instead of whatever the handler had buffered, and the
+connection is closed after it; otherwise part of
+the response is already on the wire and there is no way to finish it
+correctly, so aborted tells the caller to drop the connection at once and
+let the client see a truncated response rather than a misleading one.
+*/
+func (s *Server) runHandler(w *response.Writer, r *request.Request) (aborted bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == ErrAbortHandler {
+			aborted = true
+			return
+		}
+		log.Printf("panic serving %s %s: %v\n%s", r.RequestLine.Method, r.RequestLine.RequestTarget, v, debug.Stack())
+		if w.Reset() != nil {
+			aborted = true
+			return
+		}
+		w.SetKeepAlive(false)
+		writeError(w, response.StatusInternalServerError, "500 internal server error\n")
+	}()
+	s.handler(w, r)
+	return false
+}
+
+// aLongTimeAgo is a read deadline that makes a blocked Read return at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// requestContext returns the context for the next request on a connection,
+// which the caller cancels when the client goes away or the handler returns.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	if s.config.HandlerTimeout > 0 {
+		return context.WithTimeout(s.ctx, s.config.HandlerTimeout)
+	}
+	return context.WithCancel(s.ctx)
+}
+
+// wantsKeepAlive reports whether the client is willing to reuse the
+// connection: HTTP/1.1 persists unless it sends "Connection: close", HTTP/1.0
+// only when it sends "Connection: keep-alive" (RFC 9112 9.3).
+func wantsKeepAlive(r *request.Request) bool {
+	if r.Headers.HasToken("connection", "close") {
+		return false
+	}
+	if r.RequestLine.HTTPVersion == "1.0" {
+		return r.Headers.HasToken("connection", "keep-alive")
+	}
+	return true
+}
+
+// writeParseError answers a request the parser rejected with the status the
+// ParseError recommends and a one-line explanation. Errors from the
+// connection itself leave nobody to answer.
+func writeParseError(conn net.Conn, err error) {
+	var parseErr *request.ParseError
+	if !errors.As(err, &parseErr) {
 		return
 	}
-	s.handler(responseWriter, r)
+	msg := fmt.Sprintf("%d %s: %s\n", parseErr.StatusCode, parseErr.Kind, parseErr.Err)
+	writeError(response.NewWriter(conn), response.StatusCode(parseErr.StatusCode), msg)
+}
+
+// writeError sends a complete plain-text response the server produced on its
+// own, before or instead of calling the handler.
+func writeError(w *response.Writer, statusCode response.StatusCode, msg string) {
+	body := []byte(msg)
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "text/plain")
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+	w.Flush()
 }
 
 /* 
@@ -63,21 +341,145 @@ func (s *Server) runServer() {
 			continue
 		}
 		fmt.Println("New connection accepted")
+		s.trackConn(conn, connIdle, false)
 		go s.handleConnection(conn, s.handler)
 	}
 }
 
 // Creates a net.Listener and returns a new Server instance. Starts listening for requests inside a goroutine.
 func Serve(port int, handler Handler) (*Server, error) {
-	portStr := ":" + strconv.Itoa(port)
-	listener, err := net.Listen("tcp", portStr)
+	return ServeConfig(port, DefaultConfig(), handler)
+}
+
+// ServeConfig is Serve with explicit timeouts and limits. It listens on
+// every interface over TCP, whatever config.Network and config.Addr say.
+func ServeConfig(port int, config Config, handler Handler) (*Server, error) {
+	config.Network = "tcp"
+	config.Addr = ":" + strconv.Itoa(port)
+	return ListenAndServe(config, handler)
+}
+
+// ListenAndServe listens on config.Network and config.Addr and serves
+// connections from it inside a goroutine.
+func ListenAndServe(config Config, handler Handler) (*Server, error) {
+	listener, err := listen(config)
 	if err != nil {
-		return nil, fmt.Errorf("error when creating listener for port %d", port)
+		return nil, err
 	}
+	return ServeListenerConfig(listener, config, handler), nil
+}
+
+// ServeListener serves connections accepted from a listener the caller
+// already created, with the default config. Closing the Server closes l.
+func ServeListener(l net.Listener, handler Handler) *Server {
+	return ServeListenerConfig(l, DefaultConfig(), handler)
+}
+
+// ServeListenerConfig is ServeListener with explicit timeouts and limits.
+// config.Network, config.Addr and config.UnixSocketMode are ignored.
+func ServeListenerConfig(l net.Listener, config Config, handler Handler) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server {
-		listener: listener,
+		listener: l,
 		handler: handler,
+		config: config,
+		conns: map[net.Conn]connState{},
+		ctx: ctx,
+		cancel: cancel,
 	}
 	go server.runServer()
-	return server, nil
+	return server
+}
+
+// Addr returns the address the server is listening on, e.g. to find the
+// port picked for ":0".
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+var ErrUnsupportedNetwork = fmt.Errorf("unsupported network")
+
+func listen(config Config) (net.Listener, error) {
+	network := config.Network
+	if network == "" {
+		network = DefaultNetwork
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		listener, err := net.Listen(network, config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("error when creating listener on %s %q: %w", network, config.Addr, err)
+		}
+		return listener, nil
+	case "unix":
+		return listenUnix(config.Addr, config.UnixSocketMode)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedNetwork, network)
+}
+
+// listenUnix listens on a Unix domain socket at path. A socket file left
+// behind by a previous run that did not shut down cleanly is removed first;
+// any other kind of file at path is left alone and the listen fails.
+//
+// With a mode, the socket is created in a private directory next to path,
+// given the mode and only then renamed into place, so that it is never
+// reachable with the permissions the umask would give it.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("error when creating listener on unix %q: file exists", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("error when creating listener on unix %q: socket is in use", path)
+		}
+		os.Remove(path)
+	}
+	if mode == 0 {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("error when creating listener on unix %q: %w", path, err)
+		}
+		return listener, nil
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-") // mode 0700
+	if err != nil {
+		return nil, fmt.Errorf("error when creating listener on unix %q: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("error when creating listener on unix %q: %w", path, err)
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error when setting mode of unix socket %q: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error when creating listener on unix %q: %w", path, err)
+	}
+	// the listener would unlink the temporary name on Close
+	listener.SetUnlinkOnClose(false)
+	return &unixListener{UnixListener: listener, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// unixListener is a socket that was renamed after it was bound; it reports
+// and cleans up the name it ended up with.
+type unixListener struct {
+	*net.UnixListener
+	addr   *net.UnixAddr
+	unlink sync.Once
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *unixListener) Close() error {
+	// only once: by the next Close the name may belong to another listener
+	l.unlink.Do(func() { os.Remove(l.addr.Name) })
+	return l.UnixListener.Close()
 }
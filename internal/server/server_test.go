@@ -0,0 +1,657 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"https/internal/headers"
+	"https/internal/request"
+	"https/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" in")
+				next(w, req)
+				order = append(order, name+" out")
+			}
+		}
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+	}
+
+	// Test: The first middleware is the outermost
+	base := NewChain(trace("a"), trace("b"))
+	extended := base.Append(trace("c"))
+	extended.Then(handler)(nil, nil)
+	assert.Equal(t, []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}, order)
+
+	// Test: Append leaves the base chain alone
+	order = nil
+	base.Then(handler)(nil, nil)
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, order)
+}
+
+func TestWriterHooks(t *testing.T) {
+	var status response.StatusCode
+	var written int
+	// records what the client actually got
+	record := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			status, written = w.StatusCode(), w.BytesWritten()
+		}
+	}
+	// turns any 500 into a 503 and shouts the body
+	rewrite := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.AddHook(response.Hook{
+				StatusLine: func(code response.StatusCode) response.StatusCode {
+					if code == response.StatusInternalServerError {
+						return response.StatusServiceUnavailable
+					}
+					return code
+				},
+				Headers: func(h *headers.Headers) {
+					h.Replace("X-Rewritten", "yes")
+				},
+				Body: func(p []byte) []byte {
+					return bytes.ToUpper(p)
+				},
+			})
+			next(w, req)
+		}
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		body := []byte("oops")
+		w.WriteStatusLine(response.StatusInternalServerError)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		n, err := w.WriteBody(body)
+		require.NoError(t, err)
+		assert.Equal(t, len(body), n)
+	}
+
+	var out bytes.Buffer
+	NewChain(record, rewrite).Then(handler)(response.NewWriter(&out), nil)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 503 "))
+	assert.Contains(t, out.String(), "X-Rewritten: yes\r\n")
+	assert.Contains(t, out.String(), "Content-Length: "+strconv.Itoa(len("oops"))+"\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nOOPS"))
+	assert.Equal(t, response.StatusServiceUnavailable, status)
+	assert.Equal(t, 4, written)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/buffered" {
+			w.Header().Add("X-Partial", "yes")
+			w.WriteHeader(response.StatusCreated)
+			w.Write([]byte("x"))
+		}
+		if req.Target.Path == "/late" {
+			w.WriteStatusLine(response.StatusOk)
+			w.WriteHeaders(response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("half"))
+		}
+		panic("boom")
+	})
+	defer s.Close()
+
+	get := func(path string) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		out, _ := io.ReadAll(conn)
+		return string(out)
+	}
+
+	// Test: A panic before the response started becomes a 500
+	out := get("/early")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 "))
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: So does a panic after the handler only picked a status and
+	// buffered some body, which is thrown away
+	out = get("/buffered")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 "))
+	assert.NotContains(t, out, "X-Partial")
+	assert.False(t, strings.HasSuffix(out, "\r\n\r\nx"))
+
+	// Test: A panic after the response started drops the connection, along
+	// with the part of the response still buffered
+	out = get("/late")
+	assert.Empty(t, out)
+
+	// Test: The server keeps serving
+	out = get("/early")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 "))
+}
+
+func TestHandleErrors(t *testing.T) {
+	run := func(h Handler) string {
+		var out bytes.Buffer
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		h(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	// Test: A HandlerError picks the status and message
+	out := run(HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return fmt.Errorf("wrapped: %w", &HandlerError{StatusCode: response.StatusNotFound, Message: "no such user"})
+	}))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 "))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n404 no such user\n"))
+
+	// Test: Any other error is a 500 that does not leak its text
+	out = run(HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return fmt.Errorf("dial tcp 10.0.0.7:5432: connection refused")
+	}))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 "))
+	assert.NotContains(t, out, "10.0.0.7")
+
+	// Test: Custom renderer
+	render := func(w *response.Writer, req *request.Request, statusCode response.StatusCode, message string) {
+		body := []byte("<p>" + message + "</p>")
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	out = run(HandleErrorsWithRenderer(func(w *response.Writer, req *request.Request) error {
+		return &HandlerError{StatusCode: response.StatusBadRequest, Message: "bad"}
+	}, render))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 "))
+	assert.True(t, strings.HasSuffix(out, "<p>bad</p>"))
+
+	// Test: An error after only picking a status still gets rendered, and
+	// what the handler buffered is thrown away
+	out = run(HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.Header().Add("X-Partial", "yes")
+		w.WriteHeader(response.StatusOk)
+		w.Write([]byte("partial"))
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "gone"}
+	}))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 "))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n404 gone\n"))
+	assert.NotContains(t, out, "X-Partial")
+	assert.NotContains(t, out, "partial")
+
+	// Test: An error after the response started aborts the connection
+	h := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOk)
+		return fmt.Errorf("too late")
+	})
+	assert.PanicsWithValue(t, ErrAbortHandler, func() { run(h) })
+}
+
+func TestExpectContinue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		w.WriteHeader(response.StatusCreated)
+		body, _ := io.ReadAll(req.Body)
+		w.Write(body)
+	})
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Picking a status before reading the body still asks for it
+	continued := "HTTP/1.1 100 Continue\r\n\r\n"
+	buf := make([]byte, len(continued))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, continued, string(buf))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	out, _ := io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 201 "))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"))
+}
+
+func TestExpectContinueStarted(t *testing.T) {
+	s := serveTest(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		body, _ := io.ReadAll(req.Body)
+		w.WriteBody(body)
+	})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: A handler that wrote its header section before reading the body
+	// sends it rather than leave the client waiting
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestExpectContinueRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := ServeListener(listener, func(w *response.Writer, req *request.Request) {
+		w.WriteHeader(response.StatusPayloadTooLarge)
+	})
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5000000\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: A request rejected without asking for its body is answered with
+	// Connection: close, since the connection cannot be reused
+	out, _ := io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 "))
+	assert.NotContains(t, string(out), "100 Continue")
+	assert.Contains(t, string(out), "Connection: close\r\n")
+}
+
+// serveTest runs a Server with config on a local port until the test ends.
+func serveTest(t *testing.T, config Config, h Handler) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := ServeListenerConfig(listener, config, h)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// exchange sends raw on a new connection to s and returns everything the
+// server sends back until it closes the connection.
+func exchange(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	out, _ := io.ReadAll(conn)
+	return string(out)
+}
+
+func TestRequestOptions(t *testing.T) {
+	config := DefaultConfig()
+	config.RequestOptions.MaxHeaderCount = 1
+	s := serveTest(t, config, func(w *response.Writer, req *request.Request) {})
+
+	// Test: The limits of the config apply to every request
+	out := exchange(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 "))
+	out = exchange(t, s, "GET / HTTP/1.0\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.0 200 "))
+}
+
+func TestListen(t *testing.T) {
+	ok := func(w *response.Writer, req *request.Request) {}
+	get := "GET / HTTP/1.0\r\n\r\n"
+	dir := t.TempDir()
+
+	// Test: A unix socket gets its mode, and is removed on Close
+	config := DefaultConfig()
+	config.Network = "unix"
+	config.Addr = filepath.Join(dir, "mode.sock")
+	config.UnixSocketMode = 0660
+	s, err := ListenAndServe(config, ok)
+	require.NoError(t, err)
+	assert.Equal(t, config.Addr, s.Addr().String())
+	fi, err := os.Stat(config.Addr)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&os.ModeSocket)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+	assert.True(t, strings.HasPrefix(exchange(t, s, get), "HTTP/1.0 200 "))
+	s.Close()
+	_, err = os.Lstat(config.Addr)
+	assert.True(t, os.IsNotExist(err))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no temporary directory is left behind")
+
+	// Test: A stale socket file is replaced
+	config.UnixSocketMode = 0
+	config.Addr = filepath.Join(dir, "stale.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: config.Addr, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	s, err = ListenAndServe(config, ok)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(exchange(t, s, get), "HTTP/1.0 200 "))
+
+	// Test: A socket in use is not
+	_, err = ListenAndServe(config, ok)
+	assert.ErrorContains(t, err, "in use")
+	s.Close()
+
+	// Test: Neither is any other kind of file
+	config.Addr = filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(config.Addr, []byte("keep"), 0600))
+	_, err = ListenAndServe(config, ok)
+	assert.Error(t, err)
+	data, err := os.ReadFile(config.Addr)
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+	config.UnixSocketMode = 0660
+	_, err = ListenAndServe(config, ok)
+	assert.Error(t, err)
+
+	// Test: TCP networks
+	config = DefaultConfig()
+	config.Network = "tcp4"
+	config.Addr = "127.0.0.1:0"
+	s, err = ListenAndServe(config, ok)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(exchange(t, s, get), "HTTP/1.0 200 "))
+	s.Close()
+
+	// Test: Unsupported network
+	config.Network = "udp"
+	_, err = ListenAndServe(config, ok)
+	assert.ErrorIs(t, err, ErrUnsupportedNetwork)
+}
+
+// dial opens a connection to s that the test closes when it ends.
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readResponse reads one response off a connection that stays open.
+func readResponse(t *testing.T, r *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// assertClosed checks that the server closed conn without sending more.
+func assertClosed(t *testing.T, r io.Reader) {
+	t.Helper()
+	n, err := r.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := serveTest(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("done"))
+	})
+
+	idle := dial(t, s)
+	_, err := idle.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	_, body := readResponse(t, idleReader)
+	assert.Equal(t, "done", body)
+
+	active := dial(t, s)
+	_, err = active.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	type result struct {
+		dropped int
+		err     error
+	}
+	shutdown := make(chan result, 1)
+	go func() {
+		dropped, err := s.Shutdown(context.Background())
+		shutdown <- result{dropped, err}
+	}()
+
+	// Test: The idle connection is closed at once, while the active one
+	// is still being served
+	assertClosed(t, idleReader)
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: The request in flight finishes, then its connection is closed
+	close(release)
+	activeReader := bufio.NewReader(active)
+	resp, body := readResponse(t, activeReader)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "done", body)
+	assertClosed(t, activeReader)
+	r := <-shutdown
+	assert.Equal(t, 0, r.dropped)
+	assert.NoError(t, r.err)
+
+	// Test: No new connections
+	_, err = net.Dial(s.Addr().Network(), s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	s := serveTest(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		close(cancelled)
+	})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, state := range s.conns {
+			if state == connActive {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	// Test: A handler that outlives the context is dropped and cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dropped, err := s.Shutdown(ctx)
+	assert.Equal(t, 1, dropped)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request context was not cancelled")
+	}
+	out, _ := io.ReadAll(conn)
+	assert.Empty(t, out)
+}
+
+func TestTimeouts(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 100 * time.Millisecond
+	config.IdleTimeout = 100 * time.Millisecond
+	config.WriteTimeout = 100 * time.Millisecond
+	s := serveTest(t, config, func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	})
+
+	// Test: A client too slow with its headers gets a 408
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+	start := time.Now()
+	out, _ := io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 408 "), string(out))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: An idle keep-alive connection is closed
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "ok", body)
+	start = time.Now()
+	assertClosed(t, reader)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: A response that misses its write deadline is never sent
+	out = []byte(exchange(t, s, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Empty(t, out)
+
+	// Test: A quick one is
+	out = []byte(exchange(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"))
+
+	// Test: A handler that outlives ReadTimeout keeps its context
+	config = DefaultConfig()
+	config.ReadTimeout = 100 * time.Millisecond
+	s = serveTest(t, config, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			w.Write([]byte("cancelled"))
+		case <-time.After(300 * time.Millisecond):
+			w.Write([]byte("ok"))
+		}
+	})
+	out = []byte(exchange(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"), string(out))
+}
+
+func TestKeepAlive(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRequestsPerConn = 3
+	s := serveTest(t, config, func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/bye" {
+			w.Header().Add("Connection", "close")
+		}
+		body, _ := io.ReadAll(req.Body)
+		w.Write([]byte(req.Target.Path + string(body)))
+	})
+
+	// Test: Pipelined requests are answered in order, bodies included
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /c HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"/a", "/bhello", "/c"} {
+		_, body := readResponse(t, reader)
+		assert.Equal(t, want, body)
+	}
+
+	// Test: A response to HEAD has the headers of a GET but no body
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("HEAD /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.ContentLength)
+	resp.Body.Close()
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "/b", body)
+
+	// Test: The last request MaxRequestsPerConn allows says so
+	conn = dial(t, s)
+	_, err = conn.Write([]byte(strings.Repeat("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 4)))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	for i := 1; i <= 3; i++ {
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, i == 3, resp.Close, i)
+	}
+	assertClosed(t, reader)
+
+	// Test: Connection: close from the client
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" +
+		"GET /ignored HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	resp, _ = readResponse(t, reader)
+	assert.True(t, resp.Close)
+	assertClosed(t, reader)
+
+	// Test: Connection: close from the handler
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET /bye HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	resp, body = readResponse(t, reader)
+	assert.Equal(t, "/bye", body)
+	assert.True(t, resp.Close)
+	assertClosed(t, reader)
+
+	// Test: HTTP/1.0 closes by default
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	resp, _ = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.0", resp.Proto)
+	assert.True(t, resp.Close)
+	assertClosed(t, reader)
+
+	// Test: HTTP/1.0 keeps the connection when asked to
+	conn = dial(t, s)
+	reader = bufio.NewReader(conn)
+	for _, path := range []string{"/one", "/two"} {
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		resp, body = readResponse(t, reader)
+		assert.Equal(t, path, body)
+		assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+		assert.False(t, resp.Close)
+	}
+}